@@ -0,0 +1,130 @@
+package log
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock provides the current time to Time and any time-dependent
+// features of this package
+type Clock interface {
+	Now() time.Time
+}
+
+// Ticker is implemented by clocks that also drive the package's
+// periodic work: StartHeartbeat, WatchGoroutines, SetReservoir, and
+// GzipBatchWriter. Tick returns a channel receiving the time once per
+// interval d, and a func that stops it. Other clocks use a time.Ticker.
+type Ticker interface {
+	Tick(d time.Duration) (c <-chan time.Time, stop func())
+}
+
+var clock Clock = systemClock{}
+
+// newTicker ticks once per interval d on the package clock
+func newTicker(d time.Duration) (c <-chan time.Time, stop func()) {
+	if t, ok := clock.(Ticker); ok {
+		return t.Tick(d)
+	}
+	t := time.NewTicker(d)
+	return t.C, t.Stop
+}
+
+// SetClock sets the clock used by the package. It returns the
+// previous clock.
+//
+//	defer log.SetClock(log.SetClock(&log.FakeClock{}))
+func SetClock(c Clock) (old Clock) {
+	old = clock
+	clock = c
+	return old
+}
+
+// Now returns the current time according to the package clock
+func Now() time.Time {
+	return clock.Now()
+}
+
+// UnixTime returns the package clock's time in Unix seconds. It is the
+// default Time function.
+func UnixTime() interface{} {
+	return Now().Unix()
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// FakeClock is a manually advanced Clock for tests. The zero value
+// starts at the zero time. It implements Ticker, so periodic work such
+// as StartHeartbeat runs only when the clock is advanced past each
+// interval.
+type FakeClock struct {
+	mu      sync.Mutex
+	t       time.Time
+	tickers []*fakeTicker
+}
+
+type fakeTicker struct {
+	every time.Duration
+	next  time.Time
+	c     chan time.Time
+}
+
+// Now implements Clock
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+// Set sets the clock to t
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	c.t = t
+	c.fire()
+	c.mu.Unlock()
+}
+
+// Advance moves the clock forward by d
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.fire()
+	c.mu.Unlock()
+}
+
+// Tick implements Ticker
+func (c *FakeClock) Tick(d time.Duration) (<-chan time.Time, func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTicker{every: d, next: c.t.Add(d), c: make(chan time.Time, 1)}
+	c.tickers = append(c.tickers, t)
+	return t.c, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for i, u := range c.tickers {
+			if u == t {
+				c.tickers = append(c.tickers[:i:i], c.tickers[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// fire sends the current time to the tickers that are due. Like a
+// time.Ticker, ticks are dropped for slow receivers.
+func (c *FakeClock) fire() {
+	for _, t := range c.tickers {
+		if t.next.After(c.t) {
+			continue
+		}
+		select {
+		case t.c <- c.t:
+		default:
+		}
+		for !t.next.After(c.t) {
+			t.next = t.next.Add(t.every)
+		}
+	}
+}
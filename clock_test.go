@@ -0,0 +1,84 @@
+package log_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/as/log"
+)
+
+func TestFakeClock(t *testing.T) {
+	fake := &log.FakeClock{}
+	fake.Set(time.Unix(1000, 0))
+	defer log.SetClock(log.SetClock(fake))
+	defer func(fn func() interface{}) { log.Time = fn }(log.Time)
+	log.Time = log.UnixTime
+
+	want := `{"svc":"test", "ts":1000, "level":"info", "msg":"tick"}`
+	if have := log.Info.Msg("tick").String(); have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+	fake.Advance(90 * time.Second)
+	want = `{"svc":"test", "ts":1090, "level":"info", "msg":"tick"}`
+	if have := log.Info.Msg("tick").String(); have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestFakeClockTicker(t *testing.T) {
+	fake := &log.FakeClock{}
+	defer log.SetClock(log.SetClock(fake))
+	w := &gateWriter{open: make(chan struct{})}
+	close(w.open)
+	defer log.SetOutput(log.SetOutput(w))
+
+	beats := func() int { return strings.Count(w.String(), "\n") }
+	waitFor := func(n int) {
+		for deadline := time.Now().Add(5 * time.Second); beats() != n; {
+			if time.Now().After(deadline) {
+				t.Fatalf("bad heartbeat count: have %d, want %d", beats(), n)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	stop := log.StartHeartbeat(time.Minute)
+	defer stop()
+	fake.Advance(59 * time.Second)
+	time.Sleep(10 * time.Millisecond)
+	if n := beats(); n != 0 {
+		t.Fatalf("heartbeat before the interval: have %d lines", n)
+	}
+	fake.Advance(time.Second)
+	waitFor(1)
+	fake.Advance(time.Minute)
+	waitFor(2)
+	stop()
+	fake.Advance(time.Minute)
+	time.Sleep(10 * time.Millisecond)
+	if n := beats(); n != 2 {
+		t.Fatalf("heartbeat after stop: have %d lines", n)
+	}
+}
+
+func TestTimeLayout(t *testing.T) {
+	fake := &log.FakeClock{}
+	fake.Set(time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC))
+	defer log.SetClock(log.SetClock(fake))
+	defer func(fn func() interface{}) { log.Time = fn }(log.Time)
+	defer func() { log.TimeLayout = "" }()
+	log.Time = func() interface{} { return log.Now() }
+	log.TimeLayout = time.RFC3339
+
+	want := `{"svc":"test", "ts":"2024-03-01T12:30:00Z", "level":"info", "msg":"tick"}`
+	if have := log.Info.Msg("tick").String(); have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+
+	log.Time = log.UnixTime
+	want = `{"svc":"test", "ts":1709296200, "level":"info", "msg":"tick"}`
+	if have := log.Info.Msg("tick").String(); have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
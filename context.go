@@ -0,0 +1,97 @@
+package log
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+type contextField struct {
+	key     string
+	extract func(context.Context) (interface{}, bool)
+}
+
+var (
+	contextMu     sync.RWMutex
+	contextFields []contextField
+)
+
+// RegisterContextField registers an extractor for a typed context
+// value. Lines returned by From include the value under key whenever
+// the extractor reports it present.
+//
+//	log.RegisterContextField("user", func(ctx context.Context) (User, bool) {
+//		u, ok := ctx.Value(userKey{}).(User)
+//		return u, ok
+//	})
+func RegisterContextField[T any](key string, extractor func(context.Context) (T, bool)) {
+	contextMu.Lock()
+	defer contextMu.Unlock()
+	contextFields = append(contextFields, contextField{key, func(ctx context.Context) (interface{}, bool) {
+		return extractor(ctx)
+	}})
+}
+
+// Baggage, if set, returns the baggage entries carried by ctx, such as
+// OpenTelemetry baggage members. Lines returned by From include them
+// as fields prefixed with "baggage.", sorted by key. Setting it keeps
+// this package free of a dependency on OpenTelemetry:
+//
+//	log.Baggage = func(ctx context.Context) map[string]string {
+//		m := map[string]string{}
+//		for _, b := range baggage.FromContext(ctx).Members() {
+//			m[b.Key()] = b.Value()
+//		}
+//		return m
+//	}
+var Baggage func(ctx context.Context) map[string]string
+
+type fieldsKey struct{}
+
+// WithContext returns a copy of ctx carrying the fields kv in addition to any
+// carried by ctx already. Lines returned by From include them.
+//
+//	ctx = log.WithContext(ctx, "reqid", id)
+//	log.From(ctx).F("handled") // "reqid":id
+func WithContext(ctx context.Context, kv ...interface{}) context.Context {
+	f, _ := ctx.Value(fieldsKey{}).(fields)
+	return context.WithValue(ctx, fieldsKey{}, f.Add(kv...))
+}
+
+// From returns the Default line with the fields carried by ctx, its
+// Baggage, and the registered context fields found in ctx
+func From(ctx context.Context) line {
+	ln := Default
+	if f, ok := ctx.Value(fieldsKey{}).(fields); ok {
+		ln = ln.Add(f...)
+	}
+	if Baggage != nil {
+		ln = ln.Add(baggage(Baggage(ctx))...)
+	}
+	contextMu.RLock()
+	defer contextMu.RUnlock()
+	for _, f := range contextFields {
+		if v, ok := f.extract(ctx); ok {
+			ln = ln.Add(f.key, v)
+		}
+	}
+	return ln
+}
+
+func baggage(m map[string]string) fields {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	f := fields{}
+	for _, k := range keys {
+		f = append(f, "baggage."+k, m[k])
+	}
+	return f
+}
+
+// FromContext is equivalent to From
+func FromContext(ctx context.Context) line {
+	return From(ctx)
+}
@@ -0,0 +1,80 @@
+package log_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/as/log"
+)
+
+type tenant struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type tenantKey struct{}
+
+func init() {
+	log.RegisterContextField("tenant", func(ctx context.Context) (tenant, bool) {
+		t, ok := ctx.Value(tenantKey{}).(tenant)
+		return t, ok
+	})
+}
+
+func TestRegisterContextField(t *testing.T) {
+	ctx := context.WithValue(context.Background(), tenantKey{}, tenant{7, "acme"})
+
+	have := log.From(ctx).Msg("billing").String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "tenant":{"id":7,"name":"acme"}, "msg":"billing"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+	have = log.From(context.Background()).Msg("billing").String()
+	want = `{"svc":"test", "ts":12345, "level":"info", "msg":"billing"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestWithContext(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+
+	ctx := log.WithContext(context.Background(), "reqid", "abc")
+	child := log.WithContext(ctx, "user", "joe")
+	log.FromContext(child).Add("n", 1).Info().F("x")
+	log.FromContext(ctx).Error().F("x")
+	log.FromContext(context.Background()).F("x")
+
+	have := buf.String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "reqid":"abc", "user":"joe", "n":1, "msg":"x"}
+{"svc":"test", "ts":12345, "level":"error", "reqid":"abc", "msg":"x"}
+{"svc":"test", "ts":12345, "level":"info", "msg":"x"}
+`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+type baggageKey struct{}
+
+func TestBaggage(t *testing.T) {
+	defer func() { log.Baggage = nil }()
+	log.Baggage = func(ctx context.Context) map[string]string {
+		m, _ := ctx.Value(baggageKey{}).(map[string]string)
+		return m
+	}
+
+	ctx := context.WithValue(context.Background(), baggageKey{}, map[string]string{"user": "joe", "tier": "gold"})
+	have := log.From(ctx).Msg("checkout").String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "baggage.tier":"gold", "baggage.user":"joe", "msg":"checkout"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+	have = log.From(context.Background()).Msg("checkout").String()
+	want = `{"svc":"test", "ts":12345, "level":"info", "msg":"checkout"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
@@ -0,0 +1,260 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Encoder serializes the ordered key-value pairs of a line. The pairs
+// arrive in emission order: svc, ts, level, tags, custom fields and msg.
+type Encoder interface {
+	Encode(kv []interface{}) string
+}
+
+// FormatJSON and FormatLogfmt are the JSON and logfmt encoders with
+// their default separators
+var (
+	FormatJSON   Encoder = JSON{}
+	FormatLogfmt Encoder = Logfmt{}
+)
+
+// Format is the Encoder used by line.String. The default is JSON.
+//
+//	log.Format = log.FormatLogfmt
+var Format = FormatJSON
+
+// JSON encodes a line as a JSON object. Sep separates the key-value
+// pairs and defaults to ", ".
+type JSON struct {
+	Sep string
+}
+
+// Encode implements Encoder
+func (e JSON) Encode(kv []interface{}) string {
+	b := getBuffer()
+	p := append(*b, '{')
+	sep := ""
+	for i := 0; i+1 < len(kv); i += 2 {
+		key, val := kv[i], kv[i+1]
+		if omit(val) {
+			continue
+		}
+		p = append(p, sep...)
+		if k, ok := key.(string); ok && plain(k) {
+			p = append(append(append(p, '"'), k...), '"')
+		} else {
+			p = append(p, fmt.Sprintf("%q", key)...)
+		}
+		p = appendJSON(append(p, ':'), val)
+		sep = or(e.Sep, ", ")
+	}
+	p = append(p, '}')
+	s := string(p)
+	*b = p
+	putBuffer(b)
+	return s
+}
+
+// appendJSON appends the JSON encoding of v, as quote returns it. The
+// common strings and integers are appended directly.
+func appendJSON(p []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case string:
+		if plain(v) {
+			return append(append(append(p, '"'), v...), '"')
+		}
+	case int:
+		return strconv.AppendInt(p, int64(v), 10)
+	case int64:
+		return strconv.AppendInt(p, v, 10)
+	}
+	return append(p, quote(v)...)
+}
+
+// plain reports whether s is printable ASCII that needs no escaping in
+// JSON or Go string literals
+func plain(s string) bool {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c < ' ' || c > '~' || c == '"' || c == '\\':
+			return false
+		case EscapeHTML && (c == '<' || c == '>' || c == '&'):
+			return false
+		}
+	}
+	return true
+}
+
+// Logfmt encodes a line as key=value pairs. Sep separates the pairs
+// and defaults to a single space. Values containing whitespace, quotes,
+// or an equals sign are quoted.
+type Logfmt struct {
+	Sep string
+}
+
+// Encode implements Encoder
+func (e Logfmt) Encode(kv []interface{}) (s string) {
+	sep := ""
+	for i := 0; i+1 < len(kv); i += 2 {
+		key, val := kv[i], kv[i+1]
+		if omit(val) {
+			continue
+		}
+		s += sep + logfmtKey(fmt.Sprint(key)) + "=" + logfmtValue(val)
+		sep = or(e.Sep, " ")
+	}
+	return s
+}
+
+// Logrus encodes a line as JSON using the field names of logrus's JSON
+// formatter: the timestamp is keyed by time and levels are lowercase.
+var Logrus Encoder = logrus{}
+
+type logrus struct{}
+
+// Encode implements Encoder
+func (logrus) Encode(kv []interface{}) string {
+	kv = append([]interface{}{}, kv...)
+	for i := 0; i+1 < len(kv); i += 2 {
+		switch kv[i] {
+		case "ts":
+			kv[i] = "time"
+		case "level":
+			kv[i+1] = strings.ToLower(fmt.Sprint(kv[i+1]))
+		}
+	}
+	return JSON{Sep: ","}.Encode(kv)
+}
+
+// truncate encodes as many pairs of kv as fit in max bytes, followed
+// by a _truncated field. If the first pair that does not fit has a
+// string value, the longest prefix of that value that fits is kept,
+// followed by the TruncationMarker.
+func truncate(enc Encoder, kv fields, max int) string {
+	mark := fields{"_truncated", true}
+	fit := func(f fields) (string, bool) {
+		s := enc.Encode(append(f, mark...))
+		return s, len(s) <= max
+	}
+	best, _ := fit(fields{})
+	for i := 0; i+1 < len(kv); i += 2 {
+		if s, ok := fit(kv[: i+2 : i+2]); ok {
+			best = s
+			continue
+		}
+		v, ok := kv[i+1].(string)
+		if !ok {
+			break
+		}
+		// search the rune offsets, excluding all of v, which did not fit
+		var at []int
+		for j := range v {
+			at = append(at, j)
+		}
+		lo, hi := 0, len(at)-1
+		for lo < hi {
+			n := (lo + hi + 1) / 2
+			s, ok := fit(kv[:i:i].Add(kv[i], v[:at[n]]+TruncationMarker))
+			if !ok {
+				hi = n - 1
+				continue
+			}
+			best, lo = s, n
+		}
+		break
+	}
+	return best
+}
+
+// Bunyan encodes a line in the JSON format of node-bunyan: svc becomes
+// name, levels are numeric, the timestamp is an ISO 8601 time, and the
+// hostname, pid, and v fields are added.
+var Bunyan Encoder = bunyan{}
+
+var bunyanLevels = map[string]int{
+	"trace": 10,
+	"debug": 20,
+	"info":  30,
+	"warn":  40,
+	"error": 50,
+	"fatal": 60,
+}
+
+var hostname, _ = os.Hostname()
+
+type bunyan struct{}
+
+// Encode implements Encoder
+func (bunyan) Encode(kv []interface{}) string {
+	var ts, msg interface{}
+	out := fields{"name", nil, "hostname", hostname, "pid", os.Getpid(), "level", bunyanLevels["info"]}
+	for i := 0; i+1 < len(kv); i += 2 {
+		switch key, val := kv[i], kv[i+1]; key {
+		case "svc":
+			out[1] = val
+		case "level":
+			if n, ok := bunyanLevels[fmt.Sprint(val)]; ok {
+				out[7] = n
+			}
+		case "ts":
+			ts = val
+		case "msg":
+			msg = val
+		default:
+			out = append(out, key, val)
+		}
+	}
+	out = append(out, "msg", msg, "time", isoTime(ts), "v", 0)
+	return JSON{Sep: ","}.Encode(out)
+}
+
+// isoTime formats t, a time.Time or Unix seconds, as an ISO 8601 time
+func isoTime(t interface{}) interface{} {
+	const layout = "2006-01-02T15:04:05.000Z07:00"
+	switch t := t.(type) {
+	case time.Time:
+		return t.UTC().Format(layout)
+	case int:
+		return time.Unix(int64(t), 0).UTC().Format(layout)
+	case int64:
+		return time.Unix(t, 0).UTC().Format(layout)
+	}
+	return t
+}
+
+// logfmtKey quotes keys that would not parse back on their own
+func logfmtKey(k string) string {
+	if k == "" || strings.IndexFunc(k, logfmtSpecial) >= 0 {
+		return strconv.Quote(k)
+	}
+	return k
+}
+
+func logfmtSpecial(r rune) bool { return r <= ' ' || r == '=' || r == '"' || r == 0x7f }
+
+func logfmtValue(v interface{}) (s string) {
+	switch v := v.(type) {
+	case string:
+		s = v
+	case bool:
+		s = strconv.FormatBool(v)
+	case fmt.Stringer, error:
+		s = fmt.Sprint(v)
+	default:
+		s = quote(v)
+	}
+	if s == "" || strings.IndexFunc(s, logfmtSpecial) >= 0 {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func or(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
@@ -0,0 +1,351 @@
+package log_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/as/log"
+)
+
+func TestLogfmtSep(t *testing.T) {
+	defer func(f log.Encoder) { log.Format = f }(log.Format)
+	log.Format = log.Logfmt{Sep: "\t"}
+
+	have := log.Info.Add("ip", "1.2.3.4").Msg("custom fields").String()
+	want := "svc=test\tts=12345\tlevel=info\tip=1.2.3.4\tmsg=\"custom fields\""
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestLogrus(t *testing.T) {
+	defer func(f log.Encoder) { log.Format = f }(log.Format)
+	log.Format = log.Logrus
+
+	ln := log.Warn.Add("user", "joe")
+	ln.Level = "WARN"
+	have := ln.Msg("slow").String()
+	want := `{"svc":"test","time":12345,"level":"warn","user":"joe","msg":"slow"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestLineFormat(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+
+	log.Info.F("before")
+	log.Info.Format(log.Logfmt{}).Add("version", "1.2").F("starting up")
+	log.Info.F("after")
+
+	have := buf.String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "msg":"before"}
+svc=test ts=12345 level=info version=1.2 msg="starting up"
+{"svc":"test", "ts":12345, "level":"info", "msg":"after"}
+`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestMaxLineBytes(t *testing.T) {
+	defer func() { log.MaxLineBytes = 0 }()
+	log.MaxLineBytes = 200
+
+	have := log.Info.Add("blob", strings.Repeat("x", 10000), "after", 1).Msg("huge").String()
+	if len(have) > 200 {
+		t.Fatalf("line too long: %d bytes", len(have))
+	}
+	if !json.Valid([]byte(have)) {
+		t.Fatalf("invalid json: %s", have)
+	}
+	want := `{"svc":"test", "ts":12345, "level":"info", "blob":"xxx`
+	if !strings.HasPrefix(have, want) || !strings.HasSuffix(have, `…", "_truncated":true}`) {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s...", have, want)
+	}
+
+	have = log.Info.Msg("small").String()
+	want = `{"svc":"test", "ts":12345, "level":"info", "msg":"small"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestMaxLineBytesBoundaries(t *testing.T) {
+	defer func() { log.MaxLineBytes = 0 }()
+	for _, v := range []string{"x", "<", "\x00", "é", "ab<", "éé", "日本語のログ"} {
+		ln := log.Info.Add("k", v).Msg("hello")
+		log.MaxLineBytes = 0
+		full := ln.String()
+		for max := 1; max <= len(full)+1; max++ {
+			log.MaxLineBytes = max
+			have := ln.String()
+			if !json.Valid([]byte(have)) {
+				t.Fatalf("max %d: invalid json: %s", max, have)
+			}
+			if len(have) > max && have != `{"_truncated":true}` {
+				t.Fatalf("max %d: line too long: %s", max, have)
+			}
+		}
+	}
+}
+
+func TestTruncationMarker(t *testing.T) {
+	defer func() { log.MaxLineBytes, log.TruncationMarker = 0, "…" }()
+	log.MaxLineBytes = 80
+	log.TruncationMarker = "..."
+
+	have := log.Info.Add("blob", strings.Repeat("x", 100)).Msg("huge").String()
+	if len(have) > 80 {
+		t.Fatalf("line too long: %d bytes", len(have))
+	}
+	if !strings.HasSuffix(have, `x...", "_truncated":true}`) || strings.Contains(have, "…") {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: ...x...\", \"_truncated\":true}", have)
+	}
+}
+
+func TestBoolAsString(t *testing.T) {
+	ln := log.Info.Add("ok", true, "nested", map[string]bool{"b": false}).Msg("flag")
+	defer func() { log.BoolAsString = false }()
+	log.BoolAsString = true
+
+	have := ln.String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "ok":"true", "nested":{"b":false}, "msg":"flag"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+	have = ln.Format(log.FormatLogfmt).String()
+	want = `svc=test ts=12345 level=info ok=true nested="{\"b\":false}" msg=flag`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestBunyan(t *testing.T) {
+	defer func(f log.Encoder) { log.Format = f }(log.Format)
+	log.Format = log.Bunyan
+
+	host, _ := os.Hostname()
+	have := log.Info.Add("user", "joe").Msg("hello").String()
+	want := fmt.Sprintf(`{"name":"test","hostname":%q,"pid":%d,"level":30,"user":"joe","msg":"hello","time":"1970-01-01T03:25:45.000Z","v":0}`, host, os.Getpid())
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestEscapeHTML(t *testing.T) {
+	ln := log.Info.Add("tag", "<b>&</b>").Msg("a < b")
+	have := ln.String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "tag":"\u003cb\u003e\u0026\u003c/b\u003e", "msg":"a \u003c b"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+
+	defer func() { log.EscapeHTML = true }()
+	log.EscapeHTML = false
+	have = ln.String()
+	want = `{"svc":"test", "ts":12345, "level":"info", "tag":"<b>&</b>", "msg":"a < b"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+// parseLogfmt splits a logfmt line into its keys and unquoted values
+func parseLogfmt(t *testing.T, s string) (kv []string) {
+	for s != "" {
+		var key string
+		if strings.HasPrefix(s, `"`) {
+			q, err := strconv.QuotedPrefix(s)
+			if err != nil {
+				t.Fatalf("bad key quoting: %q: %v", s, err)
+			}
+			key, _ = strconv.Unquote(q)
+			s = s[len(q):]
+		} else if i := strings.Index(s, "="); i >= 0 {
+			key, s = s[:i], s[i:]
+		}
+		if !strings.HasPrefix(s, "=") {
+			t.Fatalf("missing '=': %q", s)
+		}
+		val := s[1:]
+		if strings.HasPrefix(val, `"`) {
+			q, err := strconv.QuotedPrefix(val)
+			if err != nil {
+				t.Fatalf("bad quoting: %q: %v", val, err)
+			}
+			s = val[len(q):]
+			val, _ = strconv.Unquote(q)
+		} else if j := strings.Index(val, " "); j >= 0 {
+			val, s = val[:j], val[j:]
+		} else {
+			s = ""
+		}
+		kv = append(kv, key, val)
+		s = strings.TrimPrefix(s, " ")
+	}
+	return kv
+}
+
+func TestLogfmt(t *testing.T) {
+	defer func(f log.Encoder) { log.Format = f }(log.Format)
+	log.Format = log.FormatLogfmt
+
+	have := log.Error.Add(
+		"quote", `he said "hi"`,
+		"eq", "a=b",
+		"ctl", "tab\tnewline\n",
+		"path", `C:\dir`,
+		"n", 5,
+		"empty", "",
+		"nil", nil,
+		"hint", []string{},
+		"a b", "c",
+		"k=v", 1,
+	).Msg("custom fields").String()
+	want := `svc=test ts=12345 level=error quote="he said \"hi\"" eq="a=b" ctl="tab\tnewline\n" path=C:\dir n=5 "a b"=c "k=v"=1 msg="custom fields"`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+
+	kv := parseLogfmt(t, have)
+	wantkv := []string{
+		"svc", "test", "ts", "12345", "level", "error",
+		"quote", `he said "hi"`, "eq", "a=b", "ctl", "tab\tnewline\n", "path", `C:\dir`, "n", "5",
+		"a b", "c", "k=v", "1",
+		"msg", "custom fields",
+	}
+	if strings.Join(kv, "|") != strings.Join(wantkv, "|") {
+		t.Fatalf("bad round trip:\n\t\thave: %q\n\t\twant: %q", kv, wantkv)
+	}
+}
+
+// decodeMsgPack decodes one MessagePack value from p, returning it and
+// the rest of p. Maps decode to map[string]interface{} and numbers to
+// float64, as encoding/json does.
+func decodeMsgPack(t *testing.T, p []byte) (interface{}, []byte) {
+	if len(p) == 0 {
+		t.Fatal("short msgpack")
+	}
+	b, p := p[0], p[1:]
+	be := func(n int) (u uint64) {
+		for i := 0; i < n; i++ {
+			u = u<<8 | uint64(p[i])
+		}
+		p = p[n:]
+		return u
+	}
+	str := func(n int) string {
+		s := string(p[:n])
+		p = p[n:]
+		return s
+	}
+	coll := func(n int, isMap bool) interface{} {
+		m, a := map[string]interface{}{}, []interface{}{}
+		for i := 0; i < n; i++ {
+			var k, v interface{}
+			if isMap {
+				k, p = decodeMsgPack(t, p)
+			}
+			v, p = decodeMsgPack(t, p)
+			if isMap {
+				m[k.(string)] = v
+			} else {
+				a = append(a, v)
+			}
+		}
+		if isMap {
+			return m
+		}
+		return a
+	}
+	switch {
+	case b <= 0x7f:
+		return float64(b), p
+	case b >= 0xe0:
+		return float64(int8(b)), p
+	case b&0xf0 == 0x80:
+		return coll(int(b&0x0f), true), p
+	case b&0xf0 == 0x90:
+		return coll(int(b&0x0f), false), p
+	case b&0xe0 == 0xa0:
+		return str(int(b & 0x1f)), p
+	}
+	switch b {
+	case 0xc0:
+		return nil, p
+	case 0xc2, 0xc3:
+		return b == 0xc3, p
+	case 0xcb:
+		return math.Float64frombits(be(8)), p
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return float64(be(1 << (b - 0xcc))), p
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		n := 1 << (b - 0xd0)
+		return float64(int64(be(n)<<(64-8*n)) >> (64 - 8*n)), p
+	case 0xd9, 0xda, 0xdb:
+		return str(int(be(1 << (b - 0xd9)))), p
+	case 0xdc, 0xdd:
+		return coll(int(be(2<<(b-0xdc))), false), p
+	case 0xde, 0xdf:
+		return coll(int(be(2<<(b-0xde))), true), p
+	}
+	t.Fatalf("unsupported msgpack type: %#x", b)
+	return nil, nil
+}
+
+func TestMsgPack(t *testing.T) {
+	defer func(f log.Encoder) { log.Format = f }(log.Format)
+
+	ln := log.Warn.Add(
+		"user", "joe",
+		"n", 300,
+		"neg", -1000,
+		"ratio", 0.25,
+		"ok", true,
+		"long", strings.Repeat("x", 40),
+		"ids", []int{1, -2, 70000},
+		"empty", "",
+	).AddUnit("latency", 12.5, "ms").Msg("slow")
+
+	var want interface{}
+	if err := json.Unmarshal([]byte(ln.String()), &want); err != nil {
+		t.Fatalf("bad json: %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+	log.Format = log.MsgPack
+	ln.F("slow")
+	ln.F("slow")
+
+	p := buf.Bytes()
+	for i := 0; i < 2; i++ {
+		if len(p) < 4 {
+			t.Fatalf("line %d: missing length prefix", i)
+		}
+		n := int(binary.BigEndian.Uint32(p))
+		if len(p) < 4+n {
+			t.Fatalf("line %d: have %d bytes, want %d", i, len(p)-4, n)
+		}
+		have, rest := decodeMsgPack(t, p[4:4+n])
+		if len(rest) != 0 {
+			t.Fatalf("line %d: %d trailing bytes", i, len(rest))
+		}
+		if !reflect.DeepEqual(have, want) {
+			t.Fatalf("bad log:\n\t\thave: %v\n\t\twant: %v", have, want)
+		}
+		p = p[4+n:]
+	}
+	if len(p) != 0 {
+		t.Fatalf("%d trailing bytes after last line", len(p))
+	}
+}
@@ -0,0 +1,34 @@
+package log
+
+// Field is a key-value pair for line.AddKV. Its constructors make a
+// missing value a compile error instead of a dropped pair.
+type Field struct {
+	Key string
+	Val interface{}
+}
+
+// Str returns a string field
+func Str(key, val string) Field { return Field{key, val} }
+
+// Int returns an integer field
+func Int(key string, val int) Field { return Field{key, val} }
+
+// Bool returns a boolean field
+func Bool(key string, val bool) Field { return Field{key, val} }
+
+// Err returns the err field holding err
+func Err(err error) Field { return Field{"err", err} }
+
+// Any returns a field holding an arbitrary value
+func Any(key string, val interface{}) Field { return Field{key, val} }
+
+// AddKV returns a copy of l with the fields provided, like Add
+//
+//	log.Info.AddKV(log.Str("user", u), log.Int("n", 5)).F("ok")
+func (l line) AddKV(f ...Field) line {
+	kv := make([]interface{}, 0, 2*len(f))
+	for _, f := range f {
+		kv = append(kv, f.Key, f.Val)
+	}
+	return l.Add(kv...)
+}
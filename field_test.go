@@ -0,0 +1,22 @@
+package log_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/as/log"
+)
+
+func TestAddKV(t *testing.T) {
+	have := log.Info.AddKV(log.Str("a", "b"), log.Int("n", 5)).Msg("typed").String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "a":"b", "n":5, "msg":"typed"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+
+	have = log.Error.Namespace("db").AddKV(log.Bool("retry", true), log.Err(io.EOF), log.Any("ids", []int{1, 2})).Msg("typed").String()
+	want = `{"svc":"test", "ts":12345, "level":"error", "db.retry":true, "db.err":"EOF", "db.ids":[1,2], "msg":"typed"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
@@ -0,0 +1,93 @@
+package log
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Goroutine-local fields. Go has no goroutine-local storage, so they
+// are keyed by the goroutine id parsed from runtime.Stack. This is
+// slow, and only done while at least one goroutine has bound fields.
+var (
+	bound  sync.Map // goroutine id -> fields
+	nbound int64
+)
+
+// Bind attaches fields to the calling goroutine. They are emitted on
+// every line written by it, and inherited by goroutines it starts with
+// Go. The returned func restores the fields bound before the call.
+//
+//	defer log.Bind("correlation_id", id)()
+func Bind(kv ...interface{}) (unbind func()) {
+	id := goid()
+	prev := goroutineFields()
+	if len(prev) == 0 {
+		atomic.AddInt64(&nbound, 1)
+	}
+	bound.Store(id, append(prev, kv...))
+	return func() {
+		if len(prev) > 0 {
+			bound.Store(id, prev)
+		} else if _, loaded := bound.LoadAndDelete(id); loaded {
+			atomic.AddInt64(&nbound, -1)
+		}
+	}
+}
+
+// Go runs fn in a new goroutine that inherits the fields bound to the
+// calling goroutine
+func Go(fn func()) {
+	f := goroutineFields()
+	go func() {
+		if len(f) > 0 {
+			defer Bind(f...)()
+		}
+		fn()
+	}()
+}
+
+// CorrelationIDFunc generates the IDs bound by Correlate. The default
+// returns 16 random hex digits. Replace it to use ULIDs, UUIDs, or
+// deterministic IDs in tests.
+var CorrelationIDFunc = func() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// Correlate binds a new correlation_id from CorrelationIDFunc to the
+// calling goroutine, as Bind does. It returns the ID and a func that
+// unbinds it.
+//
+//	id, done := log.Correlate()
+//	defer done()
+func Correlate() (id string, unbind func()) {
+	id = CorrelationIDFunc()
+	return id, Bind("correlation_id", id)
+}
+
+// goroutineFields returns the fields bound to the calling goroutine
+func goroutineFields() fields {
+	if atomic.LoadInt64(&nbound) == 0 {
+		return nil
+	}
+	f, _ := bound.Load(goid())
+	g, _ := f.(fields)
+	return append(fields{}, g...)
+}
+
+func goid() uint64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	buf = bytes.TrimPrefix(buf, []byte("goroutine "))
+	if i := bytes.IndexByte(buf, ' '); i >= 0 {
+		buf = buf[:i]
+	}
+	id, _ := strconv.ParseUint(string(buf), 10, 64)
+	return id
+}
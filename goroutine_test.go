@@ -0,0 +1,54 @@
+package log_test
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/as/log"
+)
+
+func TestGo(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+
+	unbind := log.Bind("correlation_id", "abc")
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	log.Go(func() {
+		defer wg.Done()
+		log.Info.Add("k", "v").F("child")
+	})
+	wg.Wait()
+	unbind()
+	log.Info.F("unbound")
+
+	have := buf.String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "correlation_id":"abc", "k":"v", "msg":"child"}
+{"svc":"test", "ts":12345, "level":"info", "msg":"unbound"}
+`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestCorrelate(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+	defer func(fn func() string) { log.CorrelationIDFunc = fn }(log.CorrelationIDFunc)
+	log.CorrelationIDFunc = func() string { return "req-1" }
+
+	id, done := log.Correlate()
+	log.Info.F("correlated")
+	done()
+	if id != "req-1" {
+		t.Fatalf("bad id: have %q, want %q", id, "req-1")
+	}
+
+	have := buf.String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "correlation_id":"req-1", "msg":"correlated"}
+`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
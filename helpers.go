@@ -0,0 +1,293 @@
+package log
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"time"
+)
+
+// CacheMiss, if set, is applied to lines created by line.Cache on a
+// miss. It may bump the level or emit a metric.
+var CacheMiss func(l Line) Line
+
+// MaxAttachBytes limits the number of bytes encoded by line.Attach
+var MaxAttachBytes = 4096
+
+// ErrorClassifier returns the level used by line.Classify for err. By
+// default, context.Canceled is info, context.DeadlineExceeded is warn,
+// and other errors are errors.
+var ErrorClassifier = func(err error) string {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return Info.Level
+	case errors.Is(err, context.DeadlineExceeded):
+		return Warn.Level
+	}
+	return Error.Level
+}
+
+// ForStatus returns a copy of l with the status field set to the
+// HTTP status code and the level derived from it: 5xx is an error,
+// 4xx is a warning, and everything else is info.
+func (l line) ForStatus(code int) line {
+	switch {
+	case code >= 500:
+		l = l.Error()
+	case code >= 400:
+		l = l.Warn()
+	default:
+		l = l.Info()
+	}
+	return l.Add("status", code)
+}
+
+// Cache returns a copy of l with the cache_hit and cache_key fields
+// set. On a miss, CacheMiss is applied to the result.
+func (l line) Cache(hit bool, key string) line {
+	l = l.Add("cache_hit", hit, "cache_key", key)
+	if !hit && CacheMiss != nil {
+		l = CacheMiss(l)
+	}
+	return l
+}
+
+// Sampled returns a copy of l with the trace_sampled field set to the
+// sampling decision of the associated trace
+func (l line) Sampled(b bool) line {
+	return l.Add("trace_sampled", b)
+}
+
+// Depth returns a copy of l with the depth field set to the number
+// of stack frames above the caller
+func (l line) Depth() line {
+	pc := make([]uintptr, 64)
+	for {
+		n := runtime.Callers(2, pc)
+		if n < len(pc) {
+			return l.Add("depth", n)
+		}
+		pc = make([]uintptr, 2*len(pc))
+	}
+}
+
+// Meter emits a message-less line at the metric level with the
+// metric name, its value, and any label fields provided. The line is
+// written like any other, so the metric level weighs as info for
+// SetMinLevel and sampling.
+//
+//	log.Info.Meter("queue_depth", 12, "queue", "email")
+func (l line) Meter(name string, value float64, labels ...interface{}) {
+	l.Level = "metric"
+	l.Add("metric", name, "value", value).Add(labels...).Printf("")
+}
+
+// GRPCStatus returns a copy of l with the grpc_code and grpc_msg fields
+// extracted from err. The error, or any error it wraps, should implement
+// the GRPCStatus method used by google.golang.org/grpc/status. Other
+// errors are reported with the Unknown code and the error's text.
+//
+// The status is found with reflection, so this package does not
+// depend on grpc.
+func (l line) GRPCStatus(err error) line {
+	if err == nil {
+		return l
+	}
+	code, msg, ok := grpcStatus(err)
+	if !ok {
+		code, msg = "Unknown", err.Error()
+	}
+	return l.Add("grpc_code", code, "grpc_msg", msg)
+}
+
+func grpcStatus(err error) (code, msg string, ok bool) {
+	call := func(v reflect.Value, name string) (reflect.Value, bool) {
+		m := v.MethodByName(name)
+		if !m.IsValid() || m.Type().NumIn() != 0 || m.Type().NumOut() != 1 {
+			return reflect.Value{}, false
+		}
+		return m.Call(nil)[0], true
+	}
+	for ; err != nil; err = errors.Unwrap(err) {
+		st, ok := call(reflect.ValueOf(err), "GRPCStatus")
+		if !ok || (st.Kind() == reflect.Ptr && st.IsNil()) {
+			continue
+		}
+		c, ok1 := call(st, "Code")
+		m, ok2 := call(st, "Message")
+		if ok1 && ok2 {
+			return fmt.Sprint(c.Interface()), fmt.Sprint(m.Interface()), true
+		}
+	}
+	return "", "", false
+}
+
+// Throughput returns a copy of l with the bytes, elapsed, and
+// bytes_per_sec fields set. The rate is omitted when d is zero.
+func (l line) Throughput(bytes int64, d time.Duration) line {
+	l = l.Add("bytes", bytes, "elapsed", d)
+	if d <= 0 {
+		return l
+	}
+	return l.Add("bytes_per_sec", float64(bytes)/d.Seconds())
+}
+
+// Backoff returns a copy of l with the attempt and backoff_delay fields
+// set, for clients logging a retry before they sleep.
+//
+//	log.Warn.Backoff(n, d).Add("err", err).F("retrying")
+func (l line) Backoff(attempt int, delay time.Duration) line {
+	return l.Add("attempt", attempt, "backoff_delay", delay)
+}
+
+// Phase returns a copy of l with the duration of the named phase
+// recorded in the phases field. Repeated calls accumulate phases into
+// a single nested object.
+//
+//	log.Info.Phase("parse", d0).Phase("exec", d1).Printf("query done")
+func (l line) Phase(name string, d time.Duration) line {
+	f := l.fields.Add()
+	for i := 0; i+1 < len(f); i += 2 {
+		if p, ok := f[i+1].(phases); ok {
+			f[i+1] = p.with(name, d)
+			l.fields = f
+			return l
+		}
+	}
+	return l.Add("phases", phases{}.with(name, d))
+}
+
+type phases map[string]string
+
+func (p phases) with(name string, d time.Duration) phases {
+	q := phases{name: d.String()}
+	for k, v := range p {
+		if k != name {
+			q[k] = v
+		}
+	}
+	return q
+}
+
+// Oops logs err at the error level with the caller's location and no
+// message. It does nothing if err is nil.
+//
+//	if err != nil {
+//		log.Oops(err)
+//	}
+func Oops(err error) {
+	if err == nil {
+		return
+	}
+	ln := Error.Add("err", err)
+	if !Caller {
+		ln = ln.Add("caller", caller())
+	}
+	ln.Printf("")
+}
+
+// Diff returns a copy of l with key set to an object holding the from
+// and to values. If the values are deeply equal, l is returned as is.
+func (l line) Diff(key string, from, to interface{}) line {
+	if reflect.DeepEqual(from, to) {
+		return l
+	}
+	return l.Add(key, diff{from, to})
+}
+
+type diff struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// Source returns a copy of l with the source field set to the name of
+// the library emitting it
+func (l line) Source(lib string) line {
+	return l.Add("source", lib)
+}
+
+// Attach returns a copy of l with key set to the base64 encoding of
+// data. Data longer than MaxAttachBytes is truncated, and its original
+// length is added as key_size.
+func (l line) Attach(key string, data []byte) line {
+	if max := MaxAttachBytes; len(data) > max {
+		return l.Add(key, base64.StdEncoding.EncodeToString(data[:max]), key+"_size", len(data))
+	}
+	return l.Add(key, base64.StdEncoding.EncodeToString(data))
+}
+
+// AddUnit returns a copy of l with key set to an object holding the
+// value and its unit
+//
+//	log.Info.AddUnit("latency", 12.5, "ms") // "latency":{"value":12.5,"unit":"ms"}
+func (l line) AddUnit(key string, val float64, unit string) line {
+	return l.Add(key, object{"value", val, "unit", unit})
+}
+
+// Err returns a copy of l with the err field set to err. If err, or
+// any error it wraps, has a Fields method, the fields it returns are
+// added too. A nil err returns l as is.
+//
+//	func (e *QueryError) Fields() []interface{} { return []interface{}{"table", e.Table} }
+func (l line) Err(err error) line {
+	if err == nil {
+		return l
+	}
+	l = l.Add("err", err)
+	for ; err != nil; err = errors.Unwrap(err) {
+		if f, ok := err.(interface{ Fields() []interface{} }); ok {
+			l = l.Add(f.Fields()...)
+		}
+	}
+	return l
+}
+
+// Classify returns a copy of l with the err field set as by line.Err
+// and the level chosen by ErrorClassifier. A nil err returns l as is.
+//
+//	log.Info.Classify(ctx.Err()).F("request ended")
+func (l line) Classify(err error) line {
+	if err == nil {
+		return l
+	}
+	if level := ErrorClassifier(err); level != "" {
+		l.Level = level
+	}
+	return l.Err(err)
+}
+
+// Panic returns a copy of l with the panic_type field set from a
+// recovered value v. If v is an error, it is added as by line.Err,
+// along with the fields of the errors it wraps; otherwise its text is
+// set in the panic field. A nil v returns l as is.
+//
+//	defer func() {
+//		if v := recover(); v != nil {
+//			log.Error.Panic(v).F("recovered")
+//		}
+//	}()
+func (l line) Panic(v interface{}) line {
+	if v == nil {
+		return l
+	}
+	l = l.Add("panic_type", fmt.Sprintf("%T", v))
+	if err, ok := v.(error); ok {
+		return l.Err(err)
+	}
+	return l.Add("panic", fmt.Sprint(v))
+}
+
+// ValidationErrors returns a copy of l with the validation field set to
+// an object mapping field names to their errors, sorted by name. An
+// empty errs returns l as is.
+//
+//	log.Warn.ValidationErrors(map[string]string{"email": "required"}).F("bad request")
+func (l line) ValidationErrors(errs map[string]string) line {
+	if len(errs) == 0 {
+		return l
+	}
+	return l.Add("validation", errs)
+}
@@ -0,0 +1,298 @@
+package log_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/as/log"
+)
+
+func TestForStatus(t *testing.T) {
+	for _, tc := range []struct {
+		code  int
+		level string
+	}{
+		{200, "info"},
+		{404, "warn"},
+		{500, "error"},
+	} {
+		ln := log.Info.ForStatus(tc.code)
+		if ln.Level != tc.level {
+			t.Fatalf("status %d: bad level: have %q, want %q", tc.code, ln.Level, tc.level)
+		}
+		have := ln.Msg("done").String()
+		want := fmt.Sprintf(`{"svc":"test", "ts":12345, "level":"%s", "status":%d, "msg":"done"}`, tc.level, tc.code)
+		if have != want {
+			t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+		}
+	}
+}
+
+func TestCache(t *testing.T) {
+	defer func() { log.CacheMiss = nil }()
+	log.CacheMiss = func(l log.Line) log.Line { return l.Warn() }
+
+	have := log.Info.Cache(true, "user:1").Msg("lookup").String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "cache_hit":true, "cache_key":"user:1", "msg":"lookup"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+
+	have = log.Info.Cache(false, "user:2").Msg("lookup").String()
+	want = `{"svc":"test", "ts":12345, "level":"warn", "cache_hit":false, "cache_key":"user:2", "msg":"lookup"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestSampled(t *testing.T) {
+	for _, b := range []bool{true, false} {
+		have := log.Info.Sampled(b).Msg("traced").String()
+		want := fmt.Sprintf(`{"svc":"test", "ts":12345, "level":"info", "trace_sampled":%v, "msg":"traced"}`, b)
+		if have != want {
+			t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+		}
+	}
+}
+
+func TestDepth(t *testing.T) {
+	var depth func(n int) int
+	depth = func(n int) int {
+		if n > 0 {
+			return depth(n - 1)
+		}
+		kv := log.Info.Depth().Export()
+		d, _ := strconv.Atoi(kv[len(kv)-1])
+		return d
+	}
+	base := depth(0)
+	for _, n := range []int{1, 10, 100} {
+		if have, want := depth(n), base+n; have != want {
+			t.Fatalf("depth(%d): have %d, want %d", n, have, want)
+		}
+	}
+}
+
+func TestMeter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+
+	log.Info.Add("host", "db1").Meter("queue_depth", 12.5, "queue", "email")
+	have := strings.TrimSpace(buf.String())
+	want := `{"svc":"test", "ts":12345, "level":"metric", "host":"db1", "metric":"queue_depth", "value":12.5, "queue":"email"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+
+	buf.Reset()
+	defer log.SetMinLevel(log.SetMinLevel("error"))
+	log.Info.Meter("queue_depth", 1)
+	if buf.Len() != 0 {
+		t.Fatalf("metric below the minimum level written: %s", buf)
+	}
+}
+
+type grpcCode uint32
+
+func (c grpcCode) String() string {
+	return [...]string{"OK", "Canceled", "Unknown", "InvalidArgument", "DeadlineExceeded", "NotFound"}[c]
+}
+
+type grpcStatus struct {
+	code grpcCode
+	msg  string
+}
+
+func (s *grpcStatus) Code() grpcCode  { return s.code }
+func (s *grpcStatus) Message() string { return s.msg }
+
+type grpcError struct{ s *grpcStatus }
+
+func (e grpcError) Error() string           { return "rpc error: " + e.s.msg }
+func (e grpcError) GRPCStatus() *grpcStatus { return e.s }
+
+func TestGRPCStatus(t *testing.T) {
+	err := fmt.Errorf("get user: %w", grpcError{&grpcStatus{5, "user not found"}})
+	have := log.Error.GRPCStatus(err).Msg("rpc failed").String()
+	want := `{"svc":"test", "ts":12345, "level":"error", "grpc_code":"NotFound", "grpc_msg":"user not found", "msg":"rpc failed"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+
+	have = log.Error.GRPCStatus(io.EOF).Msg("rpc failed").String()
+	want = `{"svc":"test", "ts":12345, "level":"error", "grpc_code":"Unknown", "grpc_msg":"EOF", "msg":"rpc failed"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestThroughput(t *testing.T) {
+	have := log.Info.Throughput(3<<20, 2*time.Second).Msg("copied").String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "bytes":3145728, "elapsed":"2s", "bytes_per_sec":1572864, "msg":"copied"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+	have = log.Info.Throughput(10, 0).Msg("copied").String()
+	want = `{"svc":"test", "ts":12345, "level":"info", "bytes":10, "elapsed":"0s", "msg":"copied"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	have := log.Warn.Backoff(3, 1500*time.Millisecond).Msg("retrying").String()
+	want := `{"svc":"test", "ts":12345, "level":"warn", "attempt":3, "backoff_delay":"1.5s", "msg":"retrying"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestPhase(t *testing.T) {
+	ln := log.Info.Add("op", "query").Phase("parse", 5*time.Millisecond)
+	have := ln.Phase("exec", 2*time.Second).Msg("done").String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "op":"query", "phases":{"exec":"2s","parse":"5ms"}, "msg":"done"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+	have = ln.Msg("done").String()
+	want = `{"svc":"test", "ts":12345, "level":"info", "op":"query", "phases":{"parse":"5ms"}, "msg":"done"}`
+	if have != want {
+		t.Fatalf("phase leaked into parent line:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestOops(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+
+	log.Oops(nil)
+	_, file, line, _ := runtime.Caller(0)
+	log.Oops(io.EOF)
+
+	have := buf.String()
+	want := fmt.Sprintf(`{"svc":"test", "ts":12345, "level":"error", "err":"EOF", "caller":"%s:%d"}`+"\n", filepath.Base(file), line+1)
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	have := log.Info.Diff("replicas", 3, 5).Diff("image", "v1", "v1").Msg("scaled").String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "replicas":{"from":3,"to":5}, "msg":"scaled"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestSource(t *testing.T) {
+	have := log.Info.Source("github.com/as/cache").Msg("evicted").String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "source":"github.com/as/cache", "msg":"evicted"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestAttach(t *testing.T) {
+	have := log.Debug.Attach("frame", []byte{0xde, 0xad, 0xbe, 0xef}).Msg("rx").String()
+	want := `{"svc":"test", "ts":12345, "level":"debug", "frame":"3q2+7w==", "msg":"rx"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+
+	defer func(n int) { log.MaxAttachBytes = n }(log.MaxAttachBytes)
+	log.MaxAttachBytes = 3
+	have = log.Debug.Attach("frame", []byte("abcdefgh")).Msg("rx").String()
+	want = `{"svc":"test", "ts":12345, "level":"debug", "frame":"YWJj", "frame_size":8, "msg":"rx"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestAddUnit(t *testing.T) {
+	have := log.Info.AddUnit("latency", 12.5, "ms").Msg("served").String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "latency":{"value":12.5,"unit":"ms"}, "msg":"served"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+type queryError struct {
+	table string
+	rows  int
+}
+
+func (e queryError) Error() string         { return "query failed" }
+func (e queryError) Fields() []interface{} { return []interface{}{"table", e.table, "rows", e.rows} }
+
+func TestErr(t *testing.T) {
+	err := fmt.Errorf("list users: %w", queryError{"users", 0})
+	have := log.Error.Err(err).Err(nil).Msg("db").String()
+	want := `{"svc":"test", "ts":12345, "level":"error", "err":"list users: query failed", "table":"users", "rows":0, "msg":"db"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestPanic(t *testing.T) {
+	recovered := func(v interface{}) (r interface{}) {
+		defer func() { r = recover() }()
+		panic(v)
+	}
+	for _, tc := range []struct {
+		v    interface{}
+		want string
+	}{
+		{fmt.Errorf("list users: %w", queryError{"users", 0}), `{"svc":"test", "ts":12345, "level":"error", "panic_type":"*fmt.wrapError", "err":"list users: query failed", "table":"users", "rows":0, "msg":"recovered"}`},
+		{"boom", `{"svc":"test", "ts":12345, "level":"error", "panic_type":"string", "panic":"boom", "msg":"recovered"}`},
+	} {
+		if have := log.Error.Panic(recovered(tc.v)).Msg("recovered").String(); have != tc.want {
+			t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, tc.want)
+		}
+	}
+	if have, want := log.Error.Panic(nil).Msg("recovered").String(), `{"svc":"test", "ts":12345, "level":"error", "msg":"recovered"}`; have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{context.Canceled, `{"svc":"test", "ts":12345, "level":"info", "err":"context canceled", "msg":"done"}`},
+		{fmt.Errorf("call: %w", context.DeadlineExceeded), `{"svc":"test", "ts":12345, "level":"warn", "err":"call: context deadline exceeded", "msg":"done"}`},
+		{io.EOF, `{"svc":"test", "ts":12345, "level":"error", "err":"EOF", "msg":"done"}`},
+		{nil, `{"svc":"test", "ts":12345, "level":"info", "msg":"done"}`},
+	} {
+		if have := log.Info.Classify(tc.err).Msg("done").String(); have != tc.want {
+			t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, tc.want)
+		}
+	}
+
+	defer func(fn func(error) string) { log.ErrorClassifier = fn }(log.ErrorClassifier)
+	log.ErrorClassifier = func(error) string { return "fatal" }
+	have := log.Info.Classify(io.EOF).Msg("done").String()
+	want := `{"svc":"test", "ts":12345, "level":"fatal", "err":"EOF", "msg":"done"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestValidationErrors(t *testing.T) {
+	have := log.Warn.ValidationErrors(map[string]string{
+		"name":  "too long",
+		"email": "required",
+	}).ValidationErrors(nil).Msg("bad request").String()
+	want := `{"svc":"test", "ts":12345, "level":"warn", "validation":{"email":"required","name":"too long"}, "msg":"bad request"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
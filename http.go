@@ -0,0 +1,209 @@
+package log
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"time"
+)
+
+// Middleware is an http.Handler that logs every request served by
+// Next. Completed requests are logged at a level derived from their
+// status code. Panics in Next are recovered, logged at the error level
+// with a stack trace, and answered with a 500.
+//
+//	http.ListenAndServe(":80", log.Middleware{Next: mux})
+type Middleware struct {
+	Next http.Handler
+
+	// Deadline marks requests whose context deadline expired while
+	// Next was running with deadline_exceeded and logs them at least
+	// at the warn level.
+	Deadline bool
+
+	// BodySizes adds the req_bytes and resp_bytes fields with the
+	// number of body bytes read by Next and written by it.
+	BodySizes bool
+
+	// MaxBody, if positive, adds the req_body and resp_body fields
+	// with up to MaxBody bytes of each body. Bodies are captured as
+	// they stream through, so Next still reads the full request.
+	MaxBody int
+}
+
+// ServeHTTP implements http.Handler
+func (m Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := Now()
+	ln := Info.Add("method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
+	sw := &statusWriter{ResponseWriter: w}
+	var req *capture
+	if m.BodySizes || m.MaxBody > 0 {
+		req, sw.body = &capture{max: m.MaxBody}, &capture{max: m.MaxBody}
+		if r.Body != nil {
+			r.Body = captureReader{r.Body, req}
+		}
+	}
+	defer func() {
+		if req != nil {
+			if m.BodySizes {
+				ln = ln.Add("req_bytes", req.n, "resp_bytes", sw.body.n)
+			}
+			if m.MaxBody > 0 {
+				ln = ln.Add("req_body", string(req.buf), "resp_body", string(sw.body.buf))
+			}
+		}
+		v := recover()
+		if v == nil {
+			ln = ln.ForStatus(sw.code()).Add("elapsed", Now().Sub(start))
+			if m.Deadline && r.Context().Err() == context.DeadlineExceeded {
+				if weight(ln.Level) < weight(Warn.Level) {
+					ln = ln.Warn()
+				}
+				ln = ln.Add("deadline_exceeded", true)
+			}
+			ln.Printf("%s %s", r.Method, r.URL.Path)
+			return
+		}
+		if v == http.ErrAbortHandler {
+			panic(v)
+		}
+		if sw.status == 0 {
+			http.Error(sw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		ln.Error().Add(
+			"status", sw.code(),
+			"elapsed", Now().Sub(start),
+			"panic", fmt.Sprint(v),
+			"stack", string(debug.Stack()),
+		).Printf("panic: %v", v)
+	}()
+	m.Next.ServeHTTP(sw, r)
+}
+
+// capture counts the bytes passing through it and keeps the first max
+type capture struct {
+	n   int64
+	max int
+	buf []byte
+}
+
+func (c *capture) record(p []byte) {
+	c.n += int64(len(p))
+	if n := c.max - len(c.buf); n > 0 {
+		if n > len(p) {
+			n = len(p)
+		}
+		c.buf = append(c.buf, p[:n]...)
+	}
+}
+
+type captureReader struct {
+	io.ReadCloser
+	c *capture
+}
+
+func (r captureReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.c.record(p[:n])
+	return n, err
+}
+
+// statusWriter records the status code written to a ResponseWriter
+// and, if body is set, the body
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	body   *capture
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	if w.body != nil {
+		w.body.record(p[:n])
+	}
+	return n, err
+}
+
+// Flush flushes the underlying ResponseWriter if it is an http.Flusher,
+// for streaming handlers
+func (w *statusWriter) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hijacks the underlying connection, if the ResponseWriter
+// supports it, for WebSocket handlers
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return h.Hijack()
+}
+
+// Push initiates an HTTP/2 server push, if the ResponseWriter supports it
+func (w *statusWriter) Push(target string, opts *http.PushOptions) error {
+	p, ok := w.ResponseWriter.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return p.Push(target, opts)
+}
+
+// Unwrap returns the underlying ResponseWriter for
+// http.ResponseController
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *statusWriter) code() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// RetryAfter returns a copy of l with the retry_after field set to the
+// duration in the response's Retry-After header, which may hold either
+// a number of seconds or an HTTP date. If the header is absent or
+// invalid, l is returned as is.
+func (l line) RetryAfter(resp *http.Response) line {
+	if resp == nil {
+		return l
+	}
+	h := resp.Header.Get("Retry-After")
+	if h == "" {
+		return l
+	}
+	var d time.Duration
+	if n, err := strconv.Atoi(h); err == nil {
+		d = time.Duration(n) * time.Second
+	} else if t, err := http.ParseTime(h); err == nil {
+		d = t.Sub(Now()).Round(time.Second)
+	} else {
+		return l
+	}
+	if d < 0 {
+		d = 0
+	}
+	return l.Add("retry_after", d)
+}
@@ -0,0 +1,148 @@
+package log_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/as/log"
+)
+
+func TestMiddleware(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+	defer log.SetClock(log.SetClock(&log.FakeClock{}))
+
+	h := log.Middleware{Next: http.NotFoundHandler()}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/missing", nil))
+
+	have := buf.String()
+	want := `{"svc":"test", "ts":12345, "level":"warn", "method":"GET", "path":"/missing", "remote":"192.0.2.1:1234", "status":404, "elapsed":"0s", "msg":"GET /missing"}` + "\n"
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestMiddlewarePanic(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+
+	h := log.Middleware{Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("nil map")
+	})}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/users", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("bad status: have %d, want 500", rec.Code)
+	}
+	have := buf.String()
+	for _, want := range []string{
+		`"level":"error"`,
+		`"path":"/users"`,
+		`"status":500`,
+		`"panic":"nil map"`,
+		`"stack":"goroutine `,
+		`"msg":"panic: nil map"`,
+	} {
+		if !strings.Contains(have, want) {
+			t.Fatalf("bad log: missing %s\n\t\thave: %s", want, have)
+		}
+	}
+}
+
+func TestMiddlewareFlush(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+	defer log.SetClock(log.SetClock(&log.FakeClock{}))
+
+	h := log.Middleware{Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("ResponseWriter is not an http.Flusher")
+		}
+		f.Flush()
+		if _, _, err := w.(http.Hijacker).Hijack(); err != http.ErrNotSupported {
+			t.Fatalf("bad hijack error: have %v, want %v", err, http.ErrNotSupported)
+		}
+	})}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/events", nil))
+
+	if !rec.Flushed {
+		t.Fatal("flush not passed through")
+	}
+	have := buf.String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "method":"GET", "path":"/events", "remote":"192.0.2.1:1234", "status":200, "elapsed":"0s", "msg":"GET /events"}` + "\n"
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestMiddlewareDeadline(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+	defer log.SetClock(log.SetClock(&log.FakeClock{}))
+
+	h := log.Middleware{Deadline: true, Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		w.Write([]byte("late"))
+	})}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil).WithContext(ctx))
+
+	have := buf.String()
+	want := `{"svc":"test", "ts":12345, "level":"warn", "method":"GET", "path":"/slow", "remote":"192.0.2.1:1234", "status":200, "elapsed":"0s", "deadline_exceeded":true, "msg":"GET /slow"}` + "\n"
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2021, 12, 4, 10, 0, 0, 0, time.UTC)
+	fake := &log.FakeClock{}
+	fake.Set(now)
+	defer log.SetClock(log.SetClock(fake))
+
+	for _, tc := range []struct {
+		header, want string
+	}{
+		{"120", "2m0s"},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), "1m30s"},
+	} {
+		resp := &http.Response{Header: http.Header{"Retry-After": {tc.header}}}
+		have := log.Warn.RetryAfter(resp).Msg("throttled").String()
+		want := `{"svc":"test", "ts":12345, "level":"warn", "retry_after":"` + tc.want + `", "msg":"throttled"}`
+		if have != want {
+			t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+		}
+	}
+}
+
+func TestMiddlewareBody(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+	defer log.SetClock(log.SetClock(&log.FakeClock{}))
+
+	var got []byte
+	h := log.Middleware{BodySizes: true, MaxBody: 5, Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = ioutil.ReadAll(r.Body)
+		w.Write([]byte("created user 1"))
+	})}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/users", strings.NewReader(`{"name":"joe"}`)))
+
+	if string(got) != `{"name":"joe"}` {
+		t.Fatalf("request body consumed: handler read %q", got)
+	}
+	have := buf.String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "method":"POST", "path":"/users", "remote":"192.0.2.1:1234", "req_bytes":14, "resp_bytes":14, "req_body":"{\"nam", "resp_body":"creat", "status":200, "elapsed":"0s", "msg":"POST /users"}` + "\n"
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
@@ -9,10 +9,18 @@
 package log
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -23,10 +31,16 @@ var (
 	// Service name (can be set in main or elsewhere)
 	Service = os.Getenv("SVC")
 
-	// Time is your time function. Default is a second timestamp.
-	Time = func() interface{} {
-		return time.Now().Unix()
-	}
+	// Time is your time function. Default is a second timestamp
+	// taken from the package Clock.
+	Time = UnixTime
+
+	// TimeLayout, if set, formats the ts field with time.Format when
+	// Time returns a time.Time
+	//
+	//	log.Time = func() interface{} { return log.Now() }
+	//	log.TimeLayout = time.RFC3339
+	TimeLayout = ""
 
 	// Tags are global static fields to publish for this process on
 	// all log levels and callers
@@ -34,6 +48,107 @@ var (
 
 	// Default is the level used when calling Printf and Fatalf
 	Default = Info
+
+	// MaxConcurrentWrites limits the number of goroutines writing to
+	// the output at once. Lines written while the limit is reached are
+	// dropped and counted by Dropped, except fatal lines and lines at
+	// the NeverSample levels. Zero means no limit.
+	MaxConcurrentWrites = 0
+
+	// MsgPrefix maps a level to a prefix prepended to the msg field of
+	// lines at that level, i.e., {"error": "[ERROR] "}
+	MsgPrefix = map[string]string{}
+
+	// FatalMode controls what happens after a fatal line is written.
+	// Libraries may set FatalLog to avoid killing the host process.
+	FatalMode = FatalPanic
+
+	// Fingerprint adds an fp field to every line containing a hash of
+	// the level, msg, and field keys. Lines of the same shape share a
+	// fingerprint regardless of their field values.
+	Fingerprint = false
+
+	// MaxLineBytes limits the size of a serialized line. Longer lines
+	// are re-encoded with the field crossing the limit shortened, the
+	// fields after it dropped, and a _truncated field added. Zero means
+	// no limit.
+	MaxLineBytes = 0
+
+	// FieldTransformer, if set, rewrites the key and value of every tag
+	// and field as the line is encoded. The header fields and msg are
+	// not transformed.
+	FieldTransformer func(key string, val interface{}) (string, interface{})
+
+	// BoolAsString writes boolean field values as the strings "true" and
+	// "false" instead of JSON literals
+	BoolAsString = false
+
+	// Dedup collapses fields with the same key into one holding the
+	// last value, at the position of the first
+	Dedup = false
+
+	// TruncationMarker is appended to values shortened to fit a limit,
+	// such as MaxLineBytes. Set it to "..." for ASCII-only pipelines.
+	TruncationMarker = "…"
+
+	// NumericLevel adds a severity field after the level holding its
+	// syslog severity from Severity, for pipelines that index on a number
+	NumericLevel = false
+
+	// Severity maps level names to syslog severities for NumericLevel.
+	// Levels missing from it have DefaultSeverity.
+	Severity = map[string]int{
+		"debug": 7,
+		"info":  6,
+		"warn":  4,
+		"error": 3,
+		"fatal": 2,
+	}
+
+	// DefaultSeverity is the severity of levels missing from Severity
+	DefaultSeverity = 6
+
+	// SchemaVersion, if non-zero, is emitted as the schema field in
+	// every line's header
+	SchemaVersion = 0
+
+	// MsgFirst emits msg directly after the level instead of last
+	MsgFirst = false
+
+	// NamespaceSeparator joins a namespace and the keys added to it
+	NamespaceSeparator = "."
+
+	// GroupSeparator, if set, nests fields whose keys share a prefix
+	// ending in GroupSeparator into an object named by the prefix, at
+	// the position of the first. A prefix must be shared by at least two
+	// fields and must not be a key itself. With "_", http_method and http_path become
+	// "http":{"method":...,"path":...}.
+	GroupSeparator = ""
+
+	// EscapeHTML escapes <, >, and & in JSON strings, as json.Marshal
+	// does. Set it to false for lines displayed raw in web UIs.
+	EscapeHTML = true
+
+	// Uptime adds an uptime field to every line with the time elapsed
+	// since the process started
+	Uptime = false
+
+	// BufferSize is the initial capacity of the buffers used to write
+	// lines. Buffers that grow past twice BufferSize are not reused, so
+	// it should fit a typical line.
+	BufferSize = 512
+)
+
+// start is the monotonic process start time used by Uptime
+var start = time.Now()
+
+type fatalMode int
+
+// The fatal modes. FatalPanic is the default and may be recovered with Trap.
+const (
+	FatalPanic fatalMode = iota // panic after writing the line
+	FatalExit                   // call os.Exit(1) after writing the line
+	FatalLog                    // only write the line
 )
 
 var (
@@ -50,20 +165,284 @@ var (
 
 var stderr = io.Writer(os.Stderr)
 
+// outputMu guards stderr, levelOutputs, fallback, and outputs
+var outputMu sync.RWMutex
+
+var levelOutputs = map[string]io.Writer{}
+
+// Flush flushes the outputs that buffer lines: those with a Flush or
+// Sync method. It returns the first error. Fatal lines call it before
+// exiting or panicking.
+//
+//	defer log.Flush()
+func Flush() error {
+	outputMu.RLock()
+	w := append([]io.Writer{stderr, fallback}, outputs...)
+	for _, lw := range levelOutputs {
+		w = append(w, lw)
+	}
+	outputMu.RUnlock()
+	var err error
+	for _, w := range w {
+		if e := flush(w); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// flush calls the Flush or Sync method of w, if any. The standard
+// streams are unbuffered and not synced.
+func flush(w io.Writer) error {
+	if w == os.Stderr || w == os.Stdout {
+		return nil
+	}
+	switch w := w.(type) {
+	case interface{ Flush() error }:
+		return w.Flush()
+	case interface{ Sync() error }:
+		return w.Sync()
+	}
+	return nil
+}
+
+// WriterFunc, if set, selects the output for each line from its level,
+// fields, or any other attribute, in place of the writers set with
+// SetOutput and SetLevelOutput. When it returns nil, those are used.
+// Lines held back by SetReservoir always use them.
+//
+//	log.WriterFunc = func(l log.Line) io.Writer {
+//		if l.Level == "security" {
+//			return auditLog
+//		}
+//		return nil
+//	}
+var WriterFunc func(l Line) io.Writer
+
+// SetLevelOutput sets the output for lines at level to w in place of
+// the writer set by SetOutput. A nil w removes the override. It returns
+// the previous override, if any.
+//
+//	log.SetLevelOutput("error", alerts)
+func SetLevelOutput(level string, w io.Writer) (old io.Writer) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	old = levelOutputs[level]
+	if w == nil {
+		delete(levelOutputs, level)
+	} else {
+		levelOutputs[level] = w
+	}
+	return old
+}
+
+// tagMu guards Tags against AddTags and RemoveTag. Callers assigning
+// Tags directly are responsible for their own synchronization.
+var tagMu sync.RWMutex
+
+// AddTags appends kv to the global tags. It is safe to call while
+// other goroutines are logging, unlike assigning Tags.
+func AddTags(kv ...interface{}) {
+	tagMu.Lock()
+	Tags = Tags.Add(kv...)
+	tagMu.Unlock()
+}
+
+// RemoveTag removes every global tag with the given key. It is safe to
+// call while other goroutines are logging, and concurrently with
+// AddTags, but not with direct assignments to Tags.
+func RemoveTag(key string) {
+	tagMu.Lock()
+	Tags = Tags.Remove(key)
+	tagMu.Unlock()
+}
+
+var global atomic.Value // fields
+
+// SetGlobalContext replaces the global context, a set of fields
+// emitted after Tags on every line. Unlike Tags, it is meant to change
+// at runtime, e.g., to reflect a deployment phase. Calling it with no
+// fields clears the global context.
+func SetGlobalContext(kv ...interface{}) {
+	global.Store(append(fields{}, kv...))
+}
+
+func globalContext() fields {
+	f, _ := global.Load().(fields)
+	return f
+}
+
+func tags() fields {
+	tagMu.RLock()
+	defer tagMu.RUnlock()
+	return Tags
+}
+
 // Printf and Fatalf exist to make this package somewhat compatible with
 // the go standard log.
 func Printf(f string, v ...interface{}) { Default.F(f, v...) }
 func Fatalf(f string, v ...interface{}) { Fatal.F(f, v...) }
 
 // SetOutput sets the log output to w. It returns the previous writer used.
+// It is safe to call while other goroutines are logging.
 func SetOutput(w io.Writer) (old io.Writer) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
 	old = stderr
 	stderr = w
 	return old
 }
 
+var outputs []io.Writer
+
+// AddOutput adds w to the set of writers receiving every line in
+// addition to the output. A failing writer does not prevent the others
+// from receiving the line.
+func AddOutput(w io.Writer) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	outputs = append(append([]io.Writer{}, outputs...), w)
+}
+
+// RemoveOutput removes w from the set of writers added with AddOutput
+func RemoveOutput(w io.Writer) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	keep := []io.Writer{}
+	for _, o := range outputs {
+		if !same(o, w) {
+			keep = append(keep, o)
+		}
+	}
+	outputs = keep
+}
+
+func same(a, b io.Writer) bool {
+	ta, tb := reflect.TypeOf(a), reflect.TypeOf(b)
+	return ta == tb && ta.Comparable() && a == b
+}
+
+var fallback io.Writer
+
+// SetFallbackOutput sets a writer that receives lines the output failed
+// to write. It returns the previous fallback writer, which is nil by
+// default.
+func SetFallbackOutput(w io.Writer) (old io.Writer) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	old = fallback
+	fallback = w
+	return old
+}
+
+var writers, dropped int64
+
+// Dropped returns the number of lines dropped because
+// MaxConcurrentWrites was reached
+func Dropped() int64 {
+	return atomic.LoadInt64(&dropped)
+}
+
+// LevelWriter is implemented by outputs that treat lines differently
+// depending on their level. The package calls WriteLevel instead of
+// Write on outputs implementing it.
+type LevelWriter interface {
+	WriteLevel(level string, p []byte) (int, error)
+}
+
+// writeLevel writes p to w, using WriteLevel if w implements LevelWriter
+func writeLevel(w io.Writer, level string, p []byte) (int, error) {
+	if lw, ok := w.(LevelWriter); ok {
+		return lw.WriteLevel(level, p)
+	}
+	return w.Write(p)
+}
+
+// write outputs the serialized line s at the given level
+func write(level, s, eol string) {
+	writeTo(nil, level, s, eol)
+}
+
+// writeTo is like write, but outputs s to dst in place of the writer
+// set for the level, unless dst is nil. It reports whether the line
+// reached that writer or the fallback.
+func writeTo(dst io.Writer, level, s, eol string) bool {
+	n := atomic.AddInt64(&writers, 1)
+	defer atomic.AddInt64(&writers, -1)
+	if max := MaxConcurrentWrites; max > 0 && n > int64(max) && level != Fatal.Level && !contains(NeverSample, level) {
+		atomic.AddInt64(&dropped, 1)
+		return false
+	}
+	b := getBuffer()
+	p := append(append(*b, s...), eol...)
+	outputMu.RLock()
+	w, fb, extra := stderr, fallback, outputs
+	if lw, ok := levelOutputs[level]; ok {
+		w = lw
+	}
+	outputMu.RUnlock()
+	if dst != nil {
+		w = dst
+	}
+	_, err := writeLevel(w, level, p)
+	ok := err == nil
+	if err != nil && fb != nil {
+		_, e := writeLevel(fb, level, p)
+		ok = e == nil
+	}
+	for _, w := range extra {
+		if _, e := writeLevel(w, level, p); err == nil {
+			err = e
+		}
+	}
+	*b = p
+	putBuffer(b)
+	if err != nil {
+		onError(err)
+	}
+	return ok
+}
+
+// OnError, if set, is called with the first error returned while
+// writing a line to the output or to any output added with AddOutput.
+// It runs once per failed line, after the write, and a panic in it is
+// recovered.
+var OnError func(error)
+
+func onError(err error) {
+	fn := OnError
+	if fn == nil {
+		return
+	}
+	defer func() { recover() }()
+	fn(err)
+}
+
+var bufPool sync.Pool
+
+func getBuffer() *[]byte {
+	if b, ok := bufPool.Get().(*[]byte); ok {
+		return b
+	}
+	b := make([]byte, 0, BufferSize)
+	return &b
+}
+
+func putBuffer(b *[]byte) {
+	if cap(*b) > 2*BufferSize {
+		return
+	}
+	*b = (*b)[:0]
+	bufPool.Put(b)
+}
+
 type line struct {
-	fn func(line) line
+	fn    func(line) line
+	enc   Encoder
+	stack bool
+	ns    string
+	scope *Scope
+	pc    uintptr // caller's program counter, if known
 	fields
 	Level string
 	msg   string
@@ -84,9 +463,38 @@ func (l line) Printf(f string, v ...interface{}) {
 	if l.Level == Debug.Level && !DebugOn {
 		return
 	}
-	fmt.Fprintln(stderr, l.Msg(f, v...).String())
+	if !enabled(l.Level) {
+		return
+	}
+	if ok, meta := sampled(l.Level); ok {
+		l.fields = l.fields.Add(meta...)
+		ln := l.Msg(f, v...)
+		s, ref := ln.encode()
+		eol := l.eol()
+		var dst io.Writer
+		if fn := WriterFunc; fn != nil {
+			dst = fn(ln)
+		}
+		if l.scope != nil {
+			if l.Level == Fatal.Level {
+				l.scope.close(true)
+			} else if l.scope.hold(dst, l.Level, s, eol, ref) {
+				return
+			}
+		}
+		if !reserve(l.Level, s, eol) && writeTo(dst, l.Level, s, eol) {
+			seeStack(ref)
+		}
+	}
 	if l.Level == "fatal" {
-		panic(trapme(fmt.Sprintf("fatal: "+f, v...)))
+		Flush()
+		switch FatalMode {
+		case FatalLog:
+		case FatalExit:
+			os.Exit(1)
+		default:
+			panic(trapme(fmt.Sprintf("fatal: "+f, v...)))
+		}
 	}
 }
 
@@ -107,19 +515,127 @@ func (l line) Msg(f string, v ...interface{}) line {
 // AddFunc the attached func is executed exactly once before
 // the string is created
 func (l line) String() string {
+	s, _ := l.encode()
+	return s
+}
+
+// encode returns the line as a string and the ref of the full stack it
+// carries under StackDedup, if any
+func (l line) encode() (s, ref string) {
 	if l.fn != nil {
 		fn := l.fn
 		l.fn = nil
 		l = fn(l)
 		l.fn = fn
 	}
-	hdr := append(fields{
+	l.fields = l.fields.atLevel(l.Level)
+	msg := l.msg
+	if msg != "" {
+		msg = MsgPrefix[l.Level] + msg
+	}
+	ts := Time() // time often gets overwritten
+	if t, ok := ts.(time.Time); ok && TimeLayout != "" {
+		ts = t.Format(TimeLayout)
+	}
+	hdr := append(make(fields, 0, 16+len(l.fields)),
 		"svc", Service,
-		"ts", Time(), // time often gets overwritten
+		"ts", ts,
 		"level", l.Level,
-	}, Tags...)
+	)
+	if NumericLevel {
+		sev, ok := Severity[l.Level]
+		if !ok {
+			sev = DefaultSeverity
+		}
+		hdr = append(hdr, "severity", sev)
+	}
+	if SchemaVersion != 0 {
+		hdr = append(hdr, "schema", SchemaVersion)
+	}
+	if Uptime {
+		hdr = append(hdr, "uptime", time.Since(start))
+	}
+	if MsgFirst {
+		hdr = append(hdr, "msg", msg)
+	}
+	n := len(hdr)
+	hdr = append(hdr, tags()...)
+	hdr = append(hdr, globalContext()...)
+	hdr = append(hdr, goroutineFields()...)
 	hdr = append(hdr, l.fields...)
-	return append(hdr, "msg", l.msg).String()
+	if fn := FieldTransformer; fn != nil {
+		for i := n; i+1 < len(hdr); i += 2 {
+			k, v := fn(fmt.Sprint(hdr[i]), hdr[i+1])
+			hdr[i], hdr[i+1] = k, v
+		}
+	}
+	if Fingerprint {
+		hdr = append(hdr, "fp", l.fingerprint())
+	}
+	if l.stack || Stack && contains(StackLevels, l.Level) {
+		var f fields
+		f, ref = stackFields(stack())
+		hdr = append(hdr, f...)
+	}
+	if Caller {
+		if l.pc != 0 {
+			hdr = append(hdr, "caller", callerAt(l.pc))
+		} else {
+			hdr = append(hdr, "caller", caller())
+		}
+	}
+	if !MsgFirst {
+		hdr = append(hdr, "msg", msg)
+	}
+	if Dedup {
+		hdr = hdr.dedup()
+	}
+	if len(Redact) > 0 {
+		hdr = redact(hdr)
+	}
+	if StripNUL {
+		hdr = stripNUL(hdr)
+	}
+	if MaskPII {
+		hdr = maskPII(hdr)
+	}
+	if GroupSeparator != "" {
+		hdr = group(hdr, GroupSeparator)
+	}
+	enc := l.encoder()
+	if s := enc.Encode(hdr); MaxLineBytes <= 0 || len(s) <= MaxLineBytes {
+		return s, ref
+	}
+	return truncate(enc, hdr, MaxLineBytes), "" // the stack may be cut
+}
+
+// eol returns the terminator written after each line: a newline,
+// unless the encoder's output delimits itself
+func (l line) eol() string {
+	if _, ok := l.encoder().(framed); ok {
+		return ""
+	}
+	return "\n"
+}
+
+func (l line) encoder() Encoder {
+	if l.enc == nil {
+		return Format
+	}
+	return l.enc
+}
+
+func (l line) fingerprint() string {
+	keys := []string{}
+	for i := 0; i+1 < len(l.fields); i += 2 {
+		if !omit(l.fields[i+1]) {
+			keys = append(keys, fmt.Sprint(l.fields[i]))
+		}
+	}
+	sort.Strings(keys)
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s\x00%s\x00%s", l.Level, l.msg, strings.Join(keys, "\x00"))
+	return strconv.FormatUint(h.Sum64(), 16)
 }
 
 // Add returns a copy of the line with the custom fields provided
@@ -129,18 +645,147 @@ func (l line) String() string {
 //
 // Add always makes a deep copy.
 func (l line) Add(field ...interface{}) line {
+	if l.ns != "" {
+		field = append([]interface{}{}, field...)
+		for i := 0; i+1 < len(field); i += 2 {
+			field[i] = l.ns + NamespaceSeparator + fmt.Sprint(field[i])
+		}
+	}
 	l.fields = l.fields.Add(field...)
 	return l
 }
 
+// Namespace returns a copy of the line that prefixes the keys of
+// fields added to it with name and the NamespaceSeparator. Namespaces
+// nest.
+//
+// Info.Namespace("db").Add("rows", 5).Printf("query") // "db.rows":5
+func (l line) Namespace(name string) line {
+	if l.ns != "" {
+		name = l.ns + NamespaceSeparator + name
+	}
+	l.ns = name
+	return l
+}
+
 // Export returns the key values as a string slice
 // including any set package-scoped tags
 func (l line) Export() (kv []string) {
-	f := append(fields{}, Tags...)
-	f = append(f, l.fields...)
+	f := append(fields{}, tags()...)
+	f = append(f, globalContext()...)
+	f = append(f, l.fields.atLevel(l.Level)...)
+	if Dedup {
+		f = f.dedup()
+	}
 	return f.Export()
 }
 
+// AddAtLevel returns a copy of the line with the field provided. The
+// field is only emitted if the line's level is at least min when the
+// line is written.
+//
+//	ln := log.Info.AddAtLevel("error", "request", dump)
+//	ln.Printf("ok")          // no request field
+//	ln.Error().Printf("bad") // request field included
+func (l line) AddAtLevel(min string, key string, val interface{}) line {
+	return l.Add(key, leveled{min, val})
+}
+
+type leveled struct {
+	min string
+	val interface{}
+}
+
+// atLevel resolves the leveled values in f for lines at the given level
+func (f fields) atLevel(level string) fields {
+	i := 0
+	for ; i+1 < len(f); i += 2 {
+		if _, ok := f[i+1].(leveled); ok {
+			break
+		}
+	}
+	if i+1 >= len(f) {
+		return f // fast path
+	}
+	g := append(fields{}, f[:i]...)
+	for ; i+1 < len(f); i += 2 {
+		v, ok := f[i+1].(leveled)
+		if !ok {
+			g = append(g, f[i], f[i+1])
+		} else if weight(level) >= weight(v.min) {
+			g = append(g, f[i], v.val)
+		}
+	}
+	return g
+}
+
+var minLevel atomic.Value // string
+
+// SetMinLevel drops lines below the given level, i.e, SetMinLevel("warn")
+// drops debug and info lines. Fatal lines are always written. It is safe
+// to call while other goroutines are logging, and returns the previous
+// minimum level.
+func SetMinLevel(level string) (old string) {
+	old, _ = minLevel.Swap(level).(string)
+	return old
+}
+
+// enabled reports whether lines at level pass the minimum level
+func enabled(level string) bool {
+	min, _ := minLevel.Load().(string)
+	return min == "" || level == Fatal.Level || weight(level) >= weight(min)
+}
+
+// levels orders the levels by severity
+var levels = map[string]int{
+	"debug": 0,
+	"info":  1,
+	"warn":  2,
+	"error": 3,
+	"fatal": 4,
+}
+
+// weight returns the severity of the named level. Unknown levels have
+// the same severity as info.
+func weight(level string) int {
+	if w, ok := levels[level]; ok {
+		return w
+	}
+	return levels["info"]
+}
+
+// RegisterLevel adds a custom level with the given severity for
+// SetMinLevel. The built-in levels debug through fatal weigh 0 through
+// 4. Register levels during initialization, before logging.
+//
+//	log.RegisterLevel("security", 3)
+func RegisterLevel(name string, weight int) {
+	levels[name] = weight
+}
+
+// SetDefaultLevel sets the level of Default, and so of Printf and New,
+// to the named level. Fields attached to Default are kept. It fails if
+// the level is neither built-in nor registered with RegisterLevel.
+// Like assigning Default, it is not synchronized with logging: call it
+// during initialization, before other goroutines log.
+//
+//	log.SetDefaultLevel("debug")
+func SetDefaultLevel(level string) error {
+	if _, ok := levels[level]; !ok {
+		return fmt.Errorf("log: unknown level: %q", level)
+	}
+	Default.Level = level
+	return nil
+}
+
+// Level returns a line at the named level, which may be a custom level.
+// Unregistered levels have the same severity as info.
+//
+//	log.Level("security").Add("user", u).F("login failed")
+func Level(name string) line {
+	return line{Level: name}
+}
+
 // AddFunc return a new line with fn attached
 // The fn is executed once with every call to l.Printf(),
 // l.F(), or any function that calls l.String().
@@ -155,6 +800,46 @@ func (l line) AddFunc(fn func(ln Line) Line) Line {
 	return l
 }
 
+// Format returns a copy of l serialized with enc instead of the package
+// Format. A nil enc restores the package Format.
+func (l line) Format(enc Encoder) line {
+	l.enc = enc
+	return l
+}
+
+// AddFuncCached is like AddFunc, but fn runs at most once per ttl.
+// In between, the fields fn added to the line on its last run are
+// reused. The cache is shared by all lines derived from the result.
+//
+// fn should only add fields to the line it receives.
+func (l line) AddFuncCached(ttl time.Duration, fn func(ln Line) Line) Line {
+	c := &funcCache{ttl: ttl, fn: fn}
+	return l.AddFunc(c.apply)
+}
+
+type funcCache struct {
+	sync.Mutex
+	ttl   time.Duration
+	fn    func(Line) Line
+	until time.Time
+	extra fields
+}
+
+func (c *funcCache) apply(ln Line) Line {
+	c.Lock()
+	defer c.Unlock()
+	if now := Now(); c.extra == nil || !now.Before(c.until) {
+		n := len(ln.fields)
+		res := c.fn(ln)
+		c.extra = fields{}
+		if len(res.fields) > n {
+			c.extra = append(c.extra, res.fields[n:]...)
+		}
+		c.until = now.Add(c.ttl)
+	}
+	return ln.Add(c.extra...)
+}
+
 // New returns a log line with an extra field list
 func New(fields ...interface{}) line {
 	return Default.Add(fields...)
@@ -174,6 +859,9 @@ type fields []interface{}
 //
 // Invariant: len(kv) % 2 == true, for all calls to Export
 func (f fields) Export() (kv []string) {
+	if len(Redact) > 0 {
+		f = redact(f)
+	}
 	for i := 0; i+1 < len(f); i += 2 {
 		key, val := f[i], f[i+1]
 		if key == "" || val == "" || val == nil {
@@ -188,41 +876,127 @@ func (f fields) Export() (kv []string) {
 	return
 }
 
-func (f fields) String() (s string) {
-	sep := ""
+// String returns the fields as a JSON object
+func (f fields) String() string {
+	if Dedup {
+		f = f.dedup()
+	}
+	if len(Redact) > 0 {
+		f = redact(f)
+	}
+	return JSON{}.Encode(f)
+}
+
+// group returns a copy of f with the pairs whose keys share a prefix
+// ending in sep nested into objects. Prefixes that are keys of f are
+// not grouped, so no key is repeated.
+func group(f fields, sep string) fields {
+	prefix := func(key interface{}) string {
+		k, ok := key.(string)
+		if !ok {
+			return ""
+		}
+		i := strings.Index(k, sep)
+		if i <= 0 || i+len(sep) == len(k) {
+			return ""
+		}
+		return k[:i]
+	}
+	count := map[string]int{}
 	for i := 0; i+1 < len(f); i += 2 {
-		key, val := f[i], f[i+1]
-		if val == "" || val == nil || zero(val) {
+		if p := prefix(f[i]); p != "" {
+			count[p]++
+		}
+	}
+	for i := 0; i+1 < len(f); i += 2 {
+		delete(count, fmt.Sprint(f[i]))
+	}
+	at := map[string]int{}
+	g := fields{}
+	for i := 0; i+1 < len(f); i += 2 {
+		p := prefix(f[i])
+		if count[p] < 2 {
+			g = append(g, f[i], f[i+1])
+			continue
+		}
+		kv := fields{f[i].(string)[len(p)+len(sep):], f[i+1]}
+		if j, ok := at[p]; ok {
+			g[j+1] = append(g[j+1].(object), kv...)
+			continue
+		}
+		at[p] = len(g)
+		g = append(g, p, object(kv))
+	}
+	return g
+}
+
+// dedup returns a copy of f with one pair per key, holding the last
+// value at the position of the first
+func (f fields) dedup() fields {
+	at := map[string]int{}
+	g := fields{}
+	for i := 0; i+1 < len(f); i += 2 {
+		k := fmt.Sprint(f[i])
+		if j, ok := at[k]; ok {
+			g[j+1] = f[i+1]
 			continue
 		}
-		s += fmt.Sprintf(`%s%q:%s`, sep, key, quote(val))
-		sep = ", "
+		at[k] = len(g)
+		g = append(g, f[i], f[i+1])
 	}
-	return "{" + s + "}"
+	return g
 }
 
 func (l fields) Add(f ...interface{}) fields {
 	return append(append(fields{}, l...), f...)
 }
 
+// Remove returns a copy of the fields without any pairs matching key
+func (l fields) Remove(key string) fields {
+	f := fields{}
+	for i := 0; i+1 < len(l); i += 2 {
+		if fmt.Sprint(l[i]) == key {
+			continue
+		}
+		f = append(f, l[i], l[i+1])
+	}
+	return f
+}
+
 func quote(v interface{}) string {
 	if v == nil {
 		v = ""
 	}
-	switch v.(type) {
+	switch t := v.(type) {
+	case object:
+	case bool:
+		if BoolAsString {
+			v = strconv.FormatBool(t)
+		}
 	case fmt.Stringer, error:
 		v = fmt.Sprint(v)
 	}
-	data, _ := json.Marshal(v)
-	return string(data)
+	if EscapeHTML {
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+	return strings.TrimSuffix(buf.String(), "\n")
 }
 
 type trapme string
 
+// OnFatal, if set, is called by Trap before it exits, after the
+// outputs are flushed. Use it to close files and drain writers.
+var OnFatal func()
+
 // Trap may be used in a defer to suppress stack traces caused
 // by a call to Fatal.F or Fatal.Printf. Panics from other sources are
-// not affected. Trap calls os.Exit(1) if the panic occured from these
-// functions.
+// not affected. Trap calls Flush, OnFatal, and os.Exit(1) if the panic
+// occured from these functions.
 //
 // func main(){
 // 		defer log.Trap()
@@ -231,6 +1005,10 @@ type trapme string
 func Trap() {
 	v := recover()
 	if _, ok := v.(trapme); ok {
+		Flush()
+		if OnFatal != nil {
+			OnFatal()
+		}
 		os.Exit(1)
 	}
 	if v != nil {
@@ -238,10 +1016,22 @@ func Trap() {
 	}
 }
 
+// omit reports whether the value is empty and should not be emitted
+func omit(v interface{}) bool {
+	return v == "" || v == nil || zero(v)
+}
+
 func zero(v interface{}) bool {
-	t, ok := v.([]string)
-	if !ok {
-		return false
+	switch t := v.(type) {
+	case []string:
+		return len(t) == 0
+	case object:
+		for i := 1; i < len(t); i += 2 {
+			if !omit(t[i]) {
+				return false
+			}
+		}
+		return true
 	}
-	return len(t) == 0
+	return false
 }
@@ -1,12 +1,19 @@
 package log_test
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/as/log"
 )
@@ -40,6 +47,23 @@ func TestAdd(t *testing.T) {
 	}
 }
 
+func TestNamespace(t *testing.T) {
+	ln := log.Info.Add("op", "get").Namespace("db")
+	have := ln.Add("rows", 5).Namespace("pool").Add("idle", 2).Msg("query").String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "op":"get", "db.rows":5, "db.pool.idle":2, "msg":"query"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+
+	defer func() { log.NamespaceSeparator = "." }()
+	log.NamespaceSeparator = "_"
+	have = ln.Add("rows", 5).Msg("query").String()
+	want = `{"svc":"test", "ts":12345, "level":"info", "op":"get", "db_rows":5, "msg":"query"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
 func TestTag(t *testing.T) {
 	before := log.Tags
 	log.Tags = log.Tags.Add("subcmd", "test")
@@ -54,6 +78,150 @@ func TestTag(t *testing.T) {
 	}
 }
 
+func TestMsgPrefix(t *testing.T) {
+	defer func() { delete(log.MsgPrefix, "error") }()
+	log.MsgPrefix["error"] = "[ERROR] "
+
+	have := log.Error.Msg("disk full").String()
+	want := `{"svc":"test", "ts":12345, "level":"error", "msg":"[ERROR] disk full"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+	have = log.Info.Msg("disk ok").String()
+	want = `{"svc":"test", "ts":12345, "level":"info", "msg":"disk ok"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestAddAtLevel(t *testing.T) {
+	ln := log.Info.Add("op", "get").AddAtLevel("error", "dump", "GET / HTTP/1.1")
+
+	have := ln.Msg("ok").String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "op":"get", "msg":"ok"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+	have = ln.Error().Msg("bad").String()
+	want = `{"svc":"test", "ts":12345, "level":"error", "op":"get", "dump":"GET / HTTP/1.1", "msg":"bad"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	defer func() { log.Fingerprint = false }()
+	log.Fingerprint = true
+
+	fp := func(ln log.Line) string {
+		kv := ln.Msg("request done").String()
+		i := strings.Index(kv, `"fp":`)
+		if i < 0 {
+			t.Fatalf("no fingerprint: %s", kv)
+		}
+		return kv[i : i+strings.Index(kv[i:], ",")]
+	}
+	a := fp(log.Info.Add("user", "joe", "status", 200))
+	b := fp(log.Info.Add("status", 404, "user", "bob"))
+	c := fp(log.Info.Add("user", "joe"))
+	if a != b {
+		t.Fatalf("same shape, different fingerprint: %s != %s", a, b)
+	}
+	if a == c {
+		t.Fatalf("different shape, same fingerprint: %s", a)
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	defer func() { log.SchemaVersion = 0 }()
+	log.SchemaVersion = 2
+
+	have := log.Info.Add("k", "v").Msg("versioned").String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "schema":2, "k":"v", "msg":"versioned"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestMsgFirst(t *testing.T) {
+	defer func() { log.MsgFirst = false }()
+	log.MsgFirst = true
+
+	have := log.Info.Add("k", "v").Msg("first").String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "msg":"first", "k":"v"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestUptime(t *testing.T) {
+	defer func() { log.Uptime = false }()
+	log.Uptime = true
+
+	uptime := func() time.Duration {
+		var v struct{ Uptime string }
+		if err := json.Unmarshal([]byte(log.Info.Msg("up").String()), &v); err != nil {
+			t.Fatal(err)
+		}
+		d, err := time.ParseDuration(v.Uptime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	first := uptime()
+	time.Sleep(2 * time.Millisecond)
+	if second := uptime(); second <= first {
+		t.Fatalf("uptime did not increase: %s then %s", first, second)
+	}
+}
+
+func TestRemoveTag(t *testing.T) {
+	before := log.Tags
+	defer func() {
+		log.Tags = before
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_ = log.Info.String()
+		}
+	}()
+	log.AddTags("phase", "deploy", "env", "dev")
+	log.RemoveTag("phase")
+	<-done
+	have := log.Info.Msg("removed").String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "env":"dev", "msg":"removed"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestGlobalContext(t *testing.T) {
+	before := log.Tags
+	log.Tags = log.Tags.Add("env", "dev")
+	defer func() {
+		log.Tags = before
+	}()
+	defer log.SetGlobalContext()
+
+	log.SetGlobalContext("phase", "canary")
+	have := log.Info.Add("k", "v").Msg("deploying").String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "env":"dev", "phase":"canary", "k":"v", "msg":"deploying"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+
+	log.SetGlobalContext()
+	have = log.Info.Add("k", "v").Msg("deployed").String()
+	want = `{"svc":"test", "ts":12345, "level":"info", "env":"dev", "k":"v", "msg":"deployed"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
 func TestRace(t *testing.T) {
 	defer log.SetOutput(log.SetOutput(ioutil.Discard))
 
@@ -73,6 +241,432 @@ func TestRace(t *testing.T) {
 	}
 }
 
+type blockWriter struct {
+	entered chan struct{}
+	release chan struct{}
+	n, max  int64
+}
+
+func (w *blockWriter) Write(p []byte) (int, error) {
+	n := atomic.AddInt64(&w.n, 1)
+	defer atomic.AddInt64(&w.n, -1)
+	for {
+		max := atomic.LoadInt64(&w.max)
+		if n <= max || atomic.CompareAndSwapInt64(&w.max, max, n) {
+			break
+		}
+	}
+	w.entered <- struct{}{}
+	<-w.release
+	return len(p), nil
+}
+
+func TestMaxConcurrentWrites(t *testing.T) {
+	w := &blockWriter{entered: make(chan struct{}, 10), release: make(chan struct{})}
+	defer log.SetOutput(log.SetOutput(w))
+	defer func() { log.MaxConcurrentWrites = 0 }()
+	log.MaxConcurrentWrites = 2
+
+	base := log.Dropped()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			log.Info.F("stall")
+		}()
+	}
+	<-w.entered
+	<-w.entered
+	for deadline := time.Now().Add(5 * time.Second); log.Dropped()-base != 8; {
+		if time.Now().After(deadline) {
+			t.Fatalf("bad drop count: have %d, want 8", log.Dropped()-base)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(w.release)
+	wg.Wait()
+	if w.max > 2 {
+		t.Fatalf("too many concurrent writes: have %d, want 2", w.max)
+	}
+}
+
+func TestMaxConcurrentWritesExempt(t *testing.T) {
+	w := &blockWriter{entered: make(chan struct{}, 10), release: make(chan struct{})}
+	defer log.SetOutput(log.SetOutput(w))
+	defer func() { log.MaxConcurrentWrites, log.FatalMode = 0, log.FatalPanic }()
+	log.MaxConcurrentWrites, log.FatalMode = 1, log.FatalLog
+
+	base := log.Dropped()
+	wg := sync.WaitGroup{}
+	for _, ln := range []log.Line{log.Info, log.Error, log.Fatal} {
+		wg.Add(1)
+		go func(ln log.Line) {
+			defer wg.Done()
+			ln.F("stall")
+		}(ln)
+		select {
+		case <-w.entered:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s line dropped", ln.Level)
+		}
+	}
+	close(w.release)
+	wg.Wait()
+	if n := log.Dropped() - base; n != 0 {
+		t.Fatalf("bad drop count: have %d, want 0", n)
+	}
+}
+
+type errWriter struct{ err error }
+
+func (w errWriter) Write(p []byte) (int, error) { return 0, w.err }
+
+func TestFallbackOutput(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(errWriter{io.ErrClosedPipe}))
+	defer log.SetFallbackOutput(log.SetFallbackOutput(buf))
+
+	log.Error.F("sink down")
+	want := `{"svc":"test", "ts":12345, "level":"error", "msg":"sink down"}` + "\n"
+	if have := buf.String(); have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+
+	buf.Reset()
+	log.SetOutput(ioutil.Discard)
+	log.Error.F("sink up")
+	if buf.Len() != 0 {
+		t.Fatalf("fallback written on success: %s", buf)
+	}
+}
+
+func TestMsgEscaping(t *testing.T) {
+	msg := "line1\nline2\twith \"quotes\""
+	have := log.Info.Msg("%s", msg).String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "msg":"line1\nline2\twith \"quotes\""}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+	v := struct{ Msg string }{}
+	if err := json.Unmarshal([]byte(have), &v); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+	if v.Msg != msg {
+		t.Fatalf("bad msg:\n\t\thave: %q\n\t\twant: %q", v.Msg, msg)
+	}
+}
+
+func TestSetLevelOutput(t *testing.T) {
+	out, alerts := &bytes.Buffer{}, &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(out))
+	defer log.SetLevelOutput("fatal", log.SetLevelOutput("fatal", alerts))
+	defer log.SetLevelOutput("error", log.SetLevelOutput("error", alerts))
+
+	log.Info.F("routine")
+	log.Error.F("broken")
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("fatal did not panic")
+			}
+		}()
+		log.Fatal.F("down")
+	}()
+
+	have, want := out.String(), `{"svc":"test", "ts":12345, "level":"info", "msg":"routine"}
+`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+	have, want = alerts.String(), `{"svc":"test", "ts":12345, "level":"error", "msg":"broken"}
+{"svc":"test", "ts":12345, "level":"fatal", "msg":"down"}
+`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+
+	log.SetLevelOutput("error", nil)
+	log.Error.F("unrouted")
+	if !strings.Contains(out.String(), "unrouted") {
+		t.Fatalf("override not removed: %s", out)
+	}
+}
+
+func TestRegisterLevel(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+	defer log.SetMinLevel(log.SetMinLevel("warn"))
+	log.RegisterLevel("security", 3)
+
+	log.Level("security").Add("user", "joe").F("login failed")
+	log.Level("audit").F("dropped like info")
+
+	have := buf.String()
+	want := `{"svc":"test", "ts":12345, "level":"security", "user":"joe", "msg":"login failed"}
+`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestSetDefaultLevel(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+	defer func(d log.Line) { log.Default = d }(log.Default)
+
+	if err := log.SetDefaultLevel("verbose"); err == nil {
+		t.Fatal("unregistered level accepted")
+	}
+	if err := log.SetDefaultLevel("warn"); err != nil {
+		t.Fatal(err)
+	}
+	log.Printf("disk %d%% full", 90)
+
+	have := buf.String()
+	want := `{"svc":"test", "ts":12345, "level":"warn", "msg":"disk 90% full"}
+`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestNumericLevel(t *testing.T) {
+	defer func() { log.NumericLevel = false }()
+	log.NumericLevel = true
+
+	for _, tc := range []struct {
+		ln   log.Line
+		want string
+	}{
+		{log.Error, `{"svc":"test", "ts":12345, "level":"error", "severity":3, "msg":"x"}`},
+		{log.Debug, `{"svc":"test", "ts":12345, "level":"debug", "severity":7, "msg":"x"}`},
+		{log.Level("audit"), `{"svc":"test", "ts":12345, "level":"audit", "severity":6, "msg":"x"}`},
+	} {
+		if have := tc.ln.Msg("x").String(); have != tc.want {
+			t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, tc.want)
+		}
+	}
+}
+
+func TestGroupSeparator(t *testing.T) {
+	defer func() { log.GroupSeparator = "" }()
+	log.GroupSeparator = "_"
+
+	have := log.Info.Add(
+		"http_method", "GET",
+		"user", "joe",
+		"http_path", "/",
+		"http_status", 200,
+		"db_rows", 5,
+		"_private", 1,
+	).Msg("served").String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "http":{"method":"GET","path":"/","status":200}, "user":"joe", "db_rows":5, "_private":1, "msg":"served"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+
+	have = log.Info.Add("http", 1, "http_method", "GET", "http_path", "/").Msg("served").String()
+	want = `{"svc":"test", "ts":12345, "level":"info", "http":1, "http_method":"GET", "http_path":"/", "msg":"served"}`
+	if have != want {
+		t.Fatalf("prefix colliding with a key was grouped:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	defer func() { log.Dedup = false }()
+	log.Dedup = true
+
+	ln := log.Info.Add("ip", "1.2.3.4", "port", 1111).Add("ip", "5.6.7.8").Add("ip", "9.9.9.9")
+	have := ln.Msg("conn").String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "ip":"9.9.9.9", "port":1111, "msg":"conn"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+	if have, want := strings.Join(ln.Export(), ","), "ip,9.9.9.9,port,1111"; have != want {
+		t.Fatalf("bad export:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestFieldTransformer(t *testing.T) {
+	defer func() { log.FieldTransformer = nil }()
+	log.FieldTransformer = func(key string, val interface{}) (string, interface{}) {
+		return strings.ToLower(key), val
+	}
+	before := log.Tags
+	log.Tags = log.Tags.Add("Region", "us-east")
+	defer func() {
+		log.Tags = before
+	}()
+
+	have := log.Info.Add("UserID", 7).Msg("Mixed").String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "region":"us-east", "userid":7, "msg":"Mixed"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestWriterFunc(t *testing.T) {
+	out := &bytes.Buffer{}
+	tenants := map[string]*bytes.Buffer{"acme": {}, "initech": {}}
+	defer log.SetOutput(log.SetOutput(out))
+	defer func() { log.WriterFunc = nil }()
+	log.WriterFunc = func(l log.Line) io.Writer {
+		kv := l.Export()
+		for i := 0; i+1 < len(kv); i += 2 {
+			if kv[i] == "tenant" && tenants[kv[i+1]] != nil {
+				return tenants[kv[i+1]]
+			}
+		}
+		return nil
+	}
+
+	log.Info.Add("tenant", "acme").F("a")
+	log.Info.Add("tenant", "initech").F("b")
+	log.Info.Add("tenant", "unknown").F("c")
+	log.Info.F("d")
+
+	for _, tc := range []struct{ have, want string }{
+		{tenants["acme"].String(), `{"svc":"test", "ts":12345, "level":"info", "tenant":"acme", "msg":"a"}` + "\n"},
+		{tenants["initech"].String(), `{"svc":"test", "ts":12345, "level":"info", "tenant":"initech", "msg":"b"}` + "\n"},
+		{out.String(), `{"svc":"test", "ts":12345, "level":"info", "tenant":"unknown", "msg":"c"}` + "\n" +
+			`{"svc":"test", "ts":12345, "level":"info", "msg":"d"}` + "\n"},
+	} {
+		if tc.have != tc.want {
+			t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", tc.have, tc.want)
+		}
+	}
+}
+
+// flushWriter counts the calls to its Flush method
+type flushWriter struct {
+	bytes.Buffer
+	flushes int
+}
+
+func (w *flushWriter) Flush() error {
+	w.flushes++
+	return nil
+}
+
+func TestFlush(t *testing.T) {
+	w := &flushWriter{}
+	defer log.SetOutput(log.SetOutput(w))
+
+	log.Info.F("buffered")
+	if err := log.Flush(); err != nil || w.flushes != 1 {
+		t.Fatalf("bad flush: %d flushes, err %v", w.flushes, err)
+	}
+	func() {
+		defer func() { recover() }()
+		log.Fatal.F("down")
+	}()
+	if w.flushes != 2 {
+		t.Fatalf("fatal did not flush: have %d flushes, want 2", w.flushes)
+	}
+
+	log.SetOutput(ioutil.Discard)
+	if err := log.Flush(); err != nil {
+		t.Fatalf("flush without flusher: %v", err)
+	}
+}
+
+func TestOnError(t *testing.T) {
+	var errs []error
+	defer func(fn func(error)) { log.OnError = fn }(log.OnError)
+	log.OnError = func(err error) { errs = append(errs, err) }
+
+	defer log.SetOutput(log.SetOutput(ioutil.Discard))
+	log.Info.F("ok")
+	if len(errs) != 0 {
+		t.Fatalf("OnError called on success: %v", errs)
+	}
+
+	log.SetOutput(errWriter{io.ErrClosedPipe})
+	log.Info.F("one")
+	log.Info.F("two")
+	if len(errs) != 2 || errs[0] != io.ErrClosedPipe || errs[1] != io.ErrClosedPipe {
+		t.Fatalf("bad errors:\n\t\thave: %v\n\t\twant: [%v %v]", errs, io.ErrClosedPipe, io.ErrClosedPipe)
+	}
+
+	log.OnError = func(err error) { panic(err) }
+	log.Info.F("hook panics")
+}
+
+func TestMinLevel(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+	defer log.SetMinLevel(log.SetMinLevel("warn"))
+
+	log.Info.F("dropped")
+	log.Error.F("kept")
+	func() {
+		defer func() { recover() }()
+		log.Fatal.F("always kept")
+	}()
+
+	have := buf.String()
+	want := `{"svc":"test", "ts":12345, "level":"error", "msg":"kept"}
+{"svc":"test", "ts":12345, "level":"fatal", "msg":"always kept"}
+`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestAddOutput(t *testing.T) {
+	a, b := &bytes.Buffer{}, &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(errWriter{io.ErrShortWrite}))
+	log.AddOutput(a)
+	log.AddOutput(errWriter{io.ErrClosedPipe})
+	log.AddOutput(b)
+	defer log.RemoveOutput(errWriter{io.ErrClosedPipe})
+
+	log.Info.F("teed")
+	log.RemoveOutput(a)
+	log.RemoveOutput(b)
+	log.Info.F("not teed")
+
+	want := `{"svc":"test", "ts":12345, "level":"info", "msg":"teed"}` + "\n"
+	if a.String() != want || b.String() != want {
+		t.Fatalf("bad log:\n\t\thave: %q and %q\n\t\twant: %s", a, b, want)
+	}
+}
+
+func TestSetOutputRace(t *testing.T) {
+	defer log.SetOutput(log.SetOutput(ioutil.Discard))
+
+	wg := sync.WaitGroup{}
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					log.Info.F("racing")
+				}
+			}
+		}()
+	}
+	for i := 0; i < 1000; i++ {
+		old := log.SetOutput(&bytes.Buffer{})
+		if old == nil {
+			t.Fatal("SetOutput returned a nil writer")
+		}
+	}
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	if old := log.SetOutput(ioutil.Discard); old != buf {
+		t.Fatal("SetOutput did not return the previous writer")
+	}
+	close(done)
+	wg.Wait()
+}
+
 func TestFatal(t *testing.T) {
 	defer func() {
 		err := recover()
@@ -84,6 +678,79 @@ func TestFatal(t *testing.T) {
 	log.Fatal.F("panic: %v", io.EOF)
 }
 
+func TestFatalMode(t *testing.T) {
+	defer func() { log.FatalMode = log.FatalPanic }()
+
+	t.Run("Log", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		defer log.SetOutput(log.SetOutput(buf))
+		log.FatalMode = log.FatalLog
+		log.Fatal.F("still here")
+		want := `{"svc":"test", "ts":12345, "level":"fatal", "msg":"still here"}` + "\n"
+		if have := buf.String(); have != want {
+			t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+		}
+	})
+	t.Run("Panic", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("didnt panic")
+			}
+		}()
+		defer log.SetOutput(log.SetOutput(ioutil.Discard))
+		log.FatalMode = log.FatalPanic
+		log.Fatal.F("panic")
+	})
+	t.Run("Exit", func(t *testing.T) {
+		if os.Getenv("LOG_FATAL_EXIT") == "1" {
+			log.FatalMode = log.FatalExit
+			log.Fatal.F("exit")
+			return
+		}
+		cmd := exec.Command(os.Args[0], "-test.run=TestFatalMode/Exit")
+		cmd.Env = append(os.Environ(), "LOG_FATAL_EXIT=1")
+		out, err := cmd.CombinedOutput()
+		if e, ok := err.(*exec.ExitError); !ok || e.ExitCode() != 1 {
+			t.Fatalf("want exit status 1, have %v: %s", err, out)
+		}
+		if !strings.Contains(string(out), `"level":"fatal"`) {
+			t.Fatalf("missing fatal line: %s", out)
+		}
+	})
+}
+
+func TestTrap(t *testing.T) {
+	if mode := os.Getenv("LOG_TRAP"); mode != "" {
+		log.SetOutput(bufio.NewWriter(os.Stdout))
+		log.OnFatal = func() { fmt.Println("hook ran") }
+		defer log.Trap()
+		if mode == "panic" {
+			panic("boom")
+		}
+		log.Fatal.F("down")
+	}
+	run := func(mode string) (*exec.Cmd, string) {
+		cmd := exec.Command(os.Args[0], "-test.run=TestTrap$")
+		cmd.Env = append(os.Environ(), "LOG_TRAP="+mode)
+		out, _ := cmd.CombinedOutput()
+		return cmd, string(out)
+	}
+
+	cmd, out := run("fatal")
+	if cmd.ProcessState.ExitCode() != 1 {
+		t.Fatalf("want exit status 1, have %v: %s", cmd.ProcessState, out)
+	}
+	want := `{"svc":"test", "ts":12345, "level":"fatal", "msg":"down"}` + "\nhook ran\n"
+	if !strings.HasSuffix(out, want) {
+		t.Fatalf("bad output:\n\t\thave: %s\n\t\twant: ...%s", out, want)
+	}
+
+	_, out = run("panic")
+	if strings.Contains(out, "hook ran") || !strings.Contains(out, "panic: boom") {
+		t.Fatalf("other panic trapped: %s", out)
+	}
+}
+
 func TestExport(t *testing.T) {
 	before := log.Tags
 	log.Tags = log.Tags.Add("env", "dev", "version", 1, "git", "af753", "empty", "", "", 6)
@@ -107,18 +774,18 @@ func TestAddFunc(t *testing.T) {
 		// doing a line.Printf here would crash the program
 		return l
 	}
-	line.String() // 0
-	line.String() // 0
+	_ = line.String() // 0
+	_ = line.String() // 0
 
 	line = line.Error().AddFunc(fn).Add("test", "TestAddFunc")
-	line.String()              // 1
+	_ = line.String()          // 1
 	line = line.Msg("still 1") // no op
 	line.Printf("2")
 	line.F("3")
 
 	line2 := line.AddFunc(nil)
-	line2.String()
-	line2.String()
+	_ = line2.String()
+	_ = line2.String()
 
 	line.F("4")
 	if ctr != 4 {
@@ -126,6 +793,32 @@ func TestAddFunc(t *testing.T) {
 	}
 }
 
+func TestAddFuncCached(t *testing.T) {
+	fake := &log.FakeClock{}
+	defer log.SetClock(log.SetClock(fake))
+
+	ctr := 0
+	line := log.Info.AddFuncCached(time.Minute, func(l log.Line) log.Line {
+		ctr++
+		return l.Add("runs", ctr)
+	})
+	want := `{"svc":"test", "ts":12345, "level":"info", "runs":1, "msg":"x"}`
+	for i := 0; i < 3; i++ {
+		if have := line.Msg("x").String(); have != want {
+			t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+		}
+	}
+	if ctr != 1 {
+		t.Fatalf("bad count, want 1 have %d", ctr)
+	}
+
+	fake.Advance(time.Minute)
+	want = `{"svc":"test", "ts":12345, "level":"info", "runs":2, "msg":"x"}`
+	if have := line.Msg("x").String(); have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
 func TestAddArray(t *testing.T) {
 	hint := []string{}
 	hint = nil
@@ -182,6 +875,22 @@ func BenchmarkLog(b *testing.B) {
 	})
 }
 
+func BenchmarkBufferSize(b *testing.B) {
+	defer log.SetOutput(log.SetOutput(ioutil.Discard))
+	defer func(n int) { log.BufferSize = n }(log.BufferSize)
+	ln := log.Info.Add("payload", strings.Repeat("x", 900))
+
+	for _, size := range []int{64, 1024} {
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			log.BufferSize = size
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				ln.Printf("count: %d", n)
+			}
+		})
+	}
+}
+
 func ExamplePrintf() {
 	log.SetOutput(os.Stdout)
 	log.Service = "ex"
@@ -220,3 +929,20 @@ func Example_third() {
 	).Printf("error: %v", io.EOF)
 	// Output: {"svc":"ex", "ts":"2121.12.04", "level":"error", "env":"prod", "burning":true, "pi":3.14, "msg":"error: EOF"}
 }
+
+func BenchmarkString(b *testing.B) {
+	b.Run("Msg", func(b *testing.B) {
+		ln := log.Info.Msg("count: %d", 5)
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			_ = ln.String()
+		}
+	})
+	b.Run("Fields", func(b *testing.B) {
+		ln := log.Error.Add("ip", "1.2.3.4", "port", 1111, "user", "joe").Msg("count: %d", 5)
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			_ = ln.String()
+		}
+	})
+}
@@ -0,0 +1,40 @@
+package log
+
+// Logger is a set of bound fields from which lines are made at the
+// level chosen per call. It is safe to share between goroutines.
+//
+//	db := log.With("component", "db")
+//	db.Info().F("connected")
+//	db.Error().Add("err", err).F("query failed")
+type Logger struct {
+	ln line
+}
+
+// With returns a Logger bound to the Default line's fields and kv
+func With(kv ...interface{}) Logger {
+	return Default.With(kv...)
+}
+
+// With returns a Logger bound to l's fields and kv
+func (l line) With(kv ...interface{}) Logger {
+	return Logger{ln: l.Add(kv...)}
+}
+
+// With returns a copy of lg with kv bound in addition to its fields
+func (lg Logger) With(kv ...interface{}) Logger {
+	return lg.ln.With(kv...)
+}
+
+// Debug and the rest of these return a line at their level carrying
+// the bound fields
+func (lg Logger) Debug() line { return lg.at(Debug.Level) }
+func (lg Logger) Info() line  { return lg.at(Info.Level) }
+func (lg Logger) Warn() line  { return lg.at(Warn.Level) }
+func (lg Logger) Error() line { return lg.at(Error.Level) }
+func (lg Logger) Fatal() line { return lg.at(Fatal.Level) }
+
+func (lg Logger) at(level string) line {
+	l := lg.ln
+	l.Level = level
+	return l
+}
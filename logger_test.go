@@ -0,0 +1,27 @@
+package log_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/as/log"
+)
+
+func TestWith(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+
+	db := log.With("component", "db")
+	db.Info().F("connected")
+	db.With("table", "users").Error().Add("err", "EOF").F("query failed")
+	db.Warn().F("slow")
+
+	have := buf.String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "component":"db", "msg":"connected"}
+{"svc":"test", "ts":12345, "level":"error", "component":"db", "table":"users", "err":"EOF", "msg":"query failed"}
+{"svc":"test", "ts":12345, "level":"warn", "component":"db", "msg":"slow"}
+`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
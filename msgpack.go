@@ -0,0 +1,212 @@
+package log
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// MsgPack encodes a line as a MessagePack map holding the same fields
+// as JSON, preceded by its length as a 4-byte big-endian integer. Lines
+// are written without a trailing newline, so a stream of them can be
+// split by reading the length and then that many bytes.
+//
+//	log.Format = log.MsgPack
+var MsgPack Encoder = msgpack{}
+
+// framed is implemented by encoders whose output delimits itself
+type framed interface {
+	framed()
+}
+
+type msgpack struct{}
+
+func (msgpack) framed() {}
+
+// Encode implements Encoder
+func (msgpack) Encode(kv []interface{}) string {
+	var body []byte
+	n := 0
+	for i := 0; i+1 < len(kv); i += 2 {
+		key, val := kv[i], kv[i+1]
+		if omit(val) {
+			continue
+		}
+		body = mpString(body, fmt.Sprint(key))
+		body = mpValue(body, val)
+		n++
+	}
+	p := make([]byte, 4, 4+5+len(body))
+	p = append(mpHeader(p, n, 0x80, 0xde), body...)
+	binary.BigEndian.PutUint32(p, uint32(len(p)-4))
+	return string(p)
+}
+
+// mpValue appends the MessagePack encoding of v. Values other than
+// strings, booleans, and numbers are encoded as their JSON form would
+// decode, so objects and arrays keep their structure and key order.
+func mpValue(p []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(p, 0xc0)
+	case object:
+	case fmt.Stringer, error:
+		return mpString(p, fmt.Sprint(v))
+	case string:
+		return mpString(p, v)
+	case bool:
+		if BoolAsString {
+			return mpString(p, strconv.FormatBool(v))
+		}
+		if v {
+			return append(p, 0xc3)
+		}
+		return append(p, 0xc2)
+	case int:
+		return mpInt(p, int64(v))
+	case int8:
+		return mpInt(p, int64(v))
+	case int16:
+		return mpInt(p, int64(v))
+	case int32:
+		return mpInt(p, int64(v))
+	case int64:
+		return mpInt(p, v)
+	case uint:
+		return mpUint(p, uint64(v))
+	case uint8:
+		return mpUint(p, uint64(v))
+	case uint16:
+		return mpUint(p, uint64(v))
+	case uint32:
+		return mpUint(p, uint64(v))
+	case uint64:
+		return mpUint(p, v)
+	case float32:
+		return mpFloat(p, float64(v))
+	case float64:
+		return mpFloat(p, v)
+	}
+	dec := json.NewDecoder(strings.NewReader(quote(v)))
+	dec.UseNumber()
+	if q, err := mpJSON(p, dec); err == nil {
+		return q
+	}
+	return mpString(p, fmt.Sprint(v))
+}
+
+// mpJSON appends the MessagePack encoding of the next JSON value in dec
+func mpJSON(p []byte, dec *json.Decoder) ([]byte, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return p, err
+	}
+	switch tok := tok.(type) {
+	case json.Delim:
+		var (
+			body []byte
+			n    int
+		)
+		for ; dec.More(); n++ {
+			if tok == '{' {
+				k, err := dec.Token()
+				if err != nil {
+					return p, err
+				}
+				body = mpString(body, fmt.Sprint(k))
+			}
+			if body, err = mpJSON(body, dec); err != nil {
+				return p, err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return p, err
+		}
+		if tok == '{' {
+			return append(mpHeader(p, n, 0x80, 0xde), body...), nil
+		}
+		return append(mpHeader(p, n, 0x90, 0xdc), body...), nil
+	case bool:
+		if tok {
+			return append(p, 0xc3), nil
+		}
+		return append(p, 0xc2), nil
+	case json.Number:
+		if i, err := tok.Int64(); err == nil {
+			return mpInt(p, i), nil
+		}
+		f, _ := tok.Float64()
+		return mpFloat(p, f), nil
+	}
+	return mpValue(p, tok), nil
+}
+
+// mpHeader appends a map or array header for n elements, where fix is
+// the fixmap or fixarray prefix and big is the 16-bit length prefix.
+// The 32-bit length prefix always follows big.
+func mpHeader(p []byte, n int, fix, big byte) []byte {
+	switch {
+	case n < 16:
+		return append(p, fix|byte(n))
+	case n <= math.MaxUint16:
+		return append(p, big, byte(n>>8), byte(n))
+	}
+	return append(p, big+1, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+func mpString(p []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		p = append(p, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		p = append(p, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		p = append(p, 0xda, byte(n>>8), byte(n))
+	default:
+		p = append(p, 0xdb, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(p, s...)
+}
+
+func mpInt(p []byte, i int64) []byte {
+	switch {
+	case i >= 0:
+		return mpUint(p, uint64(i))
+	case i >= -32:
+		return append(p, byte(i))
+	case i >= math.MinInt8:
+		return append(p, 0xd0, byte(i))
+	case i >= math.MinInt16:
+		return append(p, 0xd1, byte(i>>8), byte(i))
+	case i >= math.MinInt32:
+		return append(p, 0xd2, byte(i>>24), byte(i>>16), byte(i>>8), byte(i))
+	}
+	return mpUint64(append(p, 0xd3), uint64(i))
+}
+
+func mpUint(p []byte, u uint64) []byte {
+	switch {
+	case u <= 127:
+		return append(p, byte(u))
+	case u <= math.MaxUint8:
+		return append(p, 0xcc, byte(u))
+	case u <= math.MaxUint16:
+		return append(p, 0xcd, byte(u>>8), byte(u))
+	case u <= math.MaxUint32:
+		return append(p, 0xce, byte(u>>24), byte(u>>16), byte(u>>8), byte(u))
+	}
+	return mpUint64(append(p, 0xcf), u)
+}
+
+func mpFloat(p []byte, f float64) []byte {
+	return mpUint64(append(p, 0xcb), math.Float64bits(f))
+}
+
+func mpUint64(p []byte, u uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], u)
+	return append(p, b[:]...)
+}
@@ -0,0 +1,124 @@
+package log
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MaskPII masks email addresses, card numbers, and social security
+// numbers found in string, error, and fmt.Stringer values, nested
+// objects, and the msg field. Card numbers must pass the Luhn check,
+// so other long digit strings, such as IDs, are kept. It is off by
+// default because every string is scanned.
+var MaskPII = false
+
+var piiPatterns = []struct {
+	re    *regexp.Regexp
+	mask  string
+	valid func(string) bool
+}{
+	{regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), "[EMAIL]", nil},
+	{regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), "[CARD]", luhn},
+	{regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`), "[SSN]", nil},
+}
+
+// maskPII returns a copy of f with personal information masked in
+// every value
+func maskPII(f fields) fields {
+	g := make(fields, len(f))
+	copy(g, f)
+	for i := 1; i < len(g); i += 2 {
+		switch v := g[i].(type) {
+		case string:
+			g[i] = mask(v)
+		case object:
+			g[i] = object(maskPII(fields(v)))
+		case fmt.Stringer, error:
+			if s := fmt.Sprint(v); mask(s) != s {
+				g[i] = mask(s)
+			}
+		}
+	}
+	return g
+}
+
+// mask returns s with personal information masked
+func mask(s string) string {
+	for _, p := range piiPatterns {
+		if p.valid == nil {
+			s = p.re.ReplaceAllString(s, p.mask)
+			continue
+		}
+		s = p.re.ReplaceAllStringFunc(s, func(m string) string {
+			if p.valid(m) {
+				return p.mask
+			}
+			return m
+		})
+	}
+	return s
+}
+
+// luhn reports whether the digits in s pass the Luhn checksum used by
+// card numbers. Separators are ignored.
+func luhn(s string) bool {
+	sum, odd := 0, false
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if odd {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum, odd = sum+d, !odd
+	}
+	return sum%10 == 0
+}
+
+// Redact lists the keys, matched case-insensitively, whose values are
+// replaced with "[REDACTED]". Empty values are still omitted.
+//
+//	log.Redact = []string{"password", "authorization"}
+var Redact []string
+
+// redact returns a copy of f with the values of the keys in Redact
+// replaced
+func redact(f fields) fields {
+	g := make(fields, len(f))
+	copy(g, f)
+	for i := 0; i+1 < len(g); i += 2 {
+		if omit(g[i+1]) {
+			continue
+		}
+		k := fmt.Sprint(g[i])
+		for _, r := range Redact {
+			if strings.EqualFold(k, r) {
+				g[i+1] = redacted
+				break
+			}
+		}
+	}
+	return g
+}
+
+// StripNUL removes NUL bytes from string values and the msg field,
+// which some log stores reject even when escaped
+var StripNUL = false
+
+// stripNUL returns a copy of f with NUL bytes removed from every string
+// value
+func stripNUL(f fields) fields {
+	g := make(fields, len(f))
+	copy(g, f)
+	for i := 1; i < len(g); i += 2 {
+		if s, ok := g[i].(string); ok {
+			g[i] = strings.ReplaceAll(s, "\x00", "")
+		}
+	}
+	return g
+}
@@ -0,0 +1,72 @@
+package log_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/as/log"
+)
+
+func TestMaskPII(t *testing.T) {
+	defer func() { log.MaskPII = false }()
+	log.MaskPII = true
+
+	have := log.Info.Add(
+		"user", "joe@example.com",
+		"card", "4111 1111 1111 1111",
+		"ssn", "078-05-1120",
+		"id", 12345,
+		"order", "1234567890123456789",
+		"err", errors.New("no account for joe@example.com"),
+		"owner", log.Obj("email", "ann@example.com", "card", "5555-5555-5555-4444"),
+	).Msg("signup from joe@example.com").String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "user":"[EMAIL]", "card":"[CARD]", "ssn":"[SSN]", "id":12345, "order":"1234567890123456789", "err":"no account for [EMAIL]", "owner":{"email":"[EMAIL]","card":"[CARD]"}, "msg":"signup from [EMAIL]"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestStripNUL(t *testing.T) {
+	ln := log.Info.Add("name", "jo\x00e", "n", 1).Msg("hi\x00")
+	have := ln.String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "name":"jo\u0000e", "n":1, "msg":"hi\u0000"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+
+	defer func() { log.StripNUL = false }()
+	log.StripNUL = true
+	have = ln.String()
+	want = `{"svc":"test", "ts":12345, "level":"info", "name":"joe", "n":1, "msg":"hi"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	before := log.Tags
+	log.Tags = log.Tags.Add("Authorization", "Bearer abc")
+	defer func() {
+		log.Tags = before
+		log.Redact = nil
+	}()
+	log.Redact = []string{"password", "authorization"}
+
+	ln := log.Info.Add("user", "joe", "PASSWORD", "hunter2", "password", "")
+	have := ln.Msg("login").String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "Authorization":"[REDACTED]", "user":"joe", "PASSWORD":"[REDACTED]", "msg":"login"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+	have = strings.Join(ln.Export(), ",")
+	want = "Authorization,[REDACTED],user,joe,PASSWORD,[REDACTED]"
+	if have != want {
+		t.Fatalf("bad export:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+	have = log.Tags.String()
+	want = `{"Authorization":"[REDACTED]"}`
+	if have != want {
+		t.Fatalf("bad tags:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
@@ -0,0 +1,63 @@
+package log
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// TagRestartCount adds the restart_count tag holding the count returned
+// by src, so lines from a crash-looping process reveal it. Call it once
+// during startup. Tags are not changed if src fails.
+//
+//	if err := log.TagRestartCount(log.RestartCountFile("/var/run/app.restarts")); err != nil {
+//		log.Warn.Add("err", err).F("restart count unavailable")
+//	}
+func TagRestartCount(src func() (int, error)) error {
+	n, err := src()
+	if err != nil {
+		return err
+	}
+	tagMu.Lock()
+	Tags = Tags.Remove("restart_count").Add("restart_count", n)
+	tagMu.Unlock()
+	return nil
+}
+
+// RestartCountFile returns a restart count source persisted in the file
+// at path. Each call increments the count stored there, so the first
+// start of a process reports zero. A missing file holds zero.
+func RestartCountFile(path string) func() (int, error) {
+	return func() (int, error) {
+		n := -1
+		data, err := ioutil.ReadFile(path)
+		if err == nil {
+			if n, err = strconv.Atoi(strings.TrimSpace(string(data))); err != nil {
+				return 0, fmt.Errorf("log: restart count: %w", err)
+			}
+		} else if !os.IsNotExist(err) {
+			return 0, err
+		}
+		n++
+		return n, ioutil.WriteFile(path, []byte(strconv.Itoa(n)+"\n"), 0644)
+	}
+}
+
+// RestartCountEnv returns a restart count source reading the integer
+// in the environment variable name, as set by a supervisor. An unset
+// variable holds zero.
+func RestartCountEnv(name string) func() (int, error) {
+	return func() (int, error) {
+		s := os.Getenv(name)
+		if s == "" {
+			return 0, nil
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, fmt.Errorf("log: restart count: %w", err)
+		}
+		return n, nil
+	}
+}
@@ -0,0 +1,46 @@
+package log_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/as/log"
+)
+
+func TestTagRestartCount(t *testing.T) {
+	before := log.Tags
+	defer func() {
+		log.Tags = before
+	}()
+
+	if err := log.TagRestartCount(func() (int, error) { return 3, nil }); err != nil {
+		t.Fatal(err)
+	}
+	have := log.Info.Msg("started").String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "restart_count":3, "msg":"started"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+
+	boom := errors.New("boom")
+	if err := log.TagRestartCount(func() (int, error) { return 0, boom }); err != boom {
+		t.Fatalf("bad error: have %v, want %v", err, boom)
+	}
+	if have := log.Info.Msg("started").String(); have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestRestartCountFile(t *testing.T) {
+	src := log.RestartCountFile(filepath.Join(t.TempDir(), "restarts"))
+	for want := 0; want < 3; want++ {
+		have, err := src()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have != want {
+			t.Fatalf("bad count: have %d, want %d", have, want)
+		}
+	}
+}
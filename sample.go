@@ -0,0 +1,310 @@
+package log
+
+import (
+	"container/list"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// SampleRate emits only one of every SampleRate lines. Values less
+	// than two disable sampling.
+	SampleRate = 0
+
+	// NeverSample lists the levels exempt from sampling
+	NeverSample = []string{"error", "fatal"}
+
+	// SampleMeta adds the sample_rate and sampled_count fields to lines
+	// kept by sampling. The count is the number of lines dropped since
+	// the previous line was kept, so downstream can reconstruct the
+	// true volume.
+	SampleMeta = false
+
+	// MaxSampleKeys limits the number of distinct keys tracked by
+	// per-key features such as line.Escalate. Once reached, the least
+	// recently used key is forgotten. Zero means no limit.
+	MaxSampleKeys = 1024
+)
+
+// sampler counts the lines offered to it and those it dropped
+type sampler struct {
+	count, dropped uint64
+}
+
+// keep reports whether the next line is kept when keeping one of every
+// n lines and, if so, how many lines were dropped since the previous
+func (s *sampler) keep(n int) (ok bool, dropped uint64) {
+	if (atomic.AddUint64(&s.count, 1)-1)%uint64(n) != 0 {
+		atomic.AddUint64(&s.dropped, 1)
+		return false, 0
+	}
+	return true, atomic.SwapUint64(&s.dropped, 0)
+}
+
+var (
+	globalSampler sampler
+
+	samplingMu sync.RWMutex
+	samplers   = map[string]*levelSampler{}
+)
+
+type levelSampler struct {
+	n int
+	sampler
+}
+
+// SetSampling emits only one of every n lines at level, starting with
+// the first, in place of SampleRate and NeverSample. Lines it keeps
+// carry the sample_rate and sampled_count fields. Values of n less than
+// two remove the level's sampling. Fatal lines are never sampled. It
+// returns the previous n for the level.
+//
+//	log.SetSampling("error", 10)
+func SetSampling(level string, n int) (old int) {
+	samplingMu.Lock()
+	defer samplingMu.Unlock()
+	if s := samplers[level]; s != nil {
+		old = s.n
+	}
+	if n < 2 {
+		delete(samplers, level)
+	} else {
+		samplers[level] = &levelSampler{n: n}
+	}
+	return old
+}
+
+// sampled reports whether a line at the given level survives sampling
+// and returns the sampling fields to add to it
+func sampled(level string) (ok bool, meta fields) {
+	if level == Fatal.Level {
+		return true, nil
+	}
+	samplingMu.RLock()
+	s := samplers[level]
+	samplingMu.RUnlock()
+	if s != nil {
+		ok, dropped := s.keep(s.n)
+		if !ok {
+			return false, nil
+		}
+		return true, fields{"sample_rate", s.n, "sampled_count", dropped}
+	}
+	n := SampleRate
+	if n < 2 || contains(NeverSample, level) {
+		return true, nil
+	}
+	ok, dropped := globalSampler.keep(n)
+	if !ok || !SampleMeta {
+		return ok, nil
+	}
+	return true, fields{"sample_rate", n, "sampled_count", dropped}
+}
+
+type reservoir struct {
+	sync.Mutex
+	level string
+	k     int
+	every time.Duration
+	start time.Time
+	seen  int
+	lines []string
+	rand  *rand.Rand
+}
+
+var (
+	reservoirMu sync.RWMutex
+	reservoirs  = map[string]*reservoir{}
+)
+
+// SetReservoir holds back lines at the given level and writes a
+// uniform random sample of at most k of them at the end of every
+// interval, in the order they were logged. Unlike SampleRate, bursts
+// are represented in proportion to their size. The fatal level is never
+// held back.
+//
+// The returned func stops the reservoir and writes its current sample.
+func SetReservoir(level string, k int, interval time.Duration) (stop func()) {
+	r := &reservoir{level: level, k: k, every: interval, start: Now(), rand: rand.New(rand.NewSource(Now().UnixNano()))}
+	reservoirMu.Lock()
+	reservoirs[level] = r
+	reservoirMu.Unlock()
+	stopTick := tick(interval, func() { r.add("", Now()) })
+	return func() {
+		stopTick()
+		reservoirMu.Lock()
+		if reservoirs[level] == r {
+			delete(reservoirs, level)
+		}
+		reservoirMu.Unlock()
+		r.Lock()
+		r.flush()
+		r.Unlock()
+	}
+}
+
+// reserve offers the serialized line s, terminated by eol, to the
+// reservoir for level. It reports whether a reservoir took the line.
+func reserve(level, s, eol string) bool {
+	if level == "fatal" {
+		return false
+	}
+	reservoirMu.RLock()
+	r := reservoirs[level]
+	reservoirMu.RUnlock()
+	if r == nil {
+		return false
+	}
+	r.add(s+eol, Now())
+	return true
+}
+
+// add flushes the sample if the interval elapsed and then, if s is
+// not empty, offers s to the sample
+func (r *reservoir) add(s string, now time.Time) {
+	r.Lock()
+	defer r.Unlock()
+	if now.Sub(r.start) >= r.every {
+		r.flush()
+		r.start = now
+	}
+	if s == "" {
+		return
+	}
+	r.seen++
+	if len(r.lines) < r.k {
+		r.lines = append(r.lines, s)
+	} else if j := r.rand.Intn(r.seen); j < r.k {
+		copy(r.lines[j:], r.lines[j+1:]) // keep the sample in log order
+		r.lines[r.k-1] = s
+	}
+}
+
+func (r *reservoir) flush() {
+	for _, s := range r.lines {
+		write(r.level, s, "")
+	}
+	r.lines, r.seen = nil, 0
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// OnEscalate is applied to lines escalated by line.Escalate. It may
+// raise an alert and choose the level. If nil, escalated lines become
+// fatal.
+var OnEscalate func(l Line) Line
+
+var (
+	escalateMu sync.Mutex
+	escalated  = newLRU[occurrences](func() int { return MaxSampleKeys })
+)
+
+// occurrences holds the times a key was escalated within its window
+type occurrences struct {
+	seen   []time.Time
+	window time.Duration
+}
+
+// Escalate records an occurrence of key and returns a copy of l with
+// the occurrences field set to the number of occurrences of key within
+// the last window. Once that number reaches threshold, the line is
+// escalated with OnEscalate.
+//
+//	log.Error.Escalate("db-conn", 5, time.Minute).Printf("dial: %v", err)
+func (l line) Escalate(key string, threshold int, window time.Duration) line {
+	now := Now()
+	escalateMu.Lock()
+	// forget the least recently used keys whose occurrences are all
+	// outside their window
+	for {
+		k, o, ok := escalated.oldest()
+		if !ok || k == key || now.Sub(o.seen[len(o.seen)-1]) < o.window {
+			break
+		}
+		escalated.remove(k)
+	}
+	o, _ := escalated.get(key)
+	seen := o.seen
+	for len(seen) > 0 && now.Sub(seen[0]) >= window {
+		seen = seen[1:]
+	}
+	seen = append(seen, now)
+	escalated.put(key, occurrences{seen, window})
+	n := len(seen)
+	escalateMu.Unlock()
+
+	l = l.Add("occurrences", n)
+	if n < threshold {
+		return l
+	}
+	if OnEscalate != nil {
+		return OnEscalate(l)
+	}
+	return l.Fatal()
+}
+
+// lru maps keys to values, forgetting the least recently used key once
+// it holds more than max keys. It is not safe for concurrent use.
+type lru[V any] struct {
+	max   func() int // zero means no limit
+	order *list.List // of *lruEntry, most recently used first
+	items map[string]*list.Element
+}
+
+type lruEntry[V any] struct {
+	key string
+	val V
+}
+
+func newLRU[V any](max func() int) *lru[V] {
+	return &lru[V]{max: max, order: list.New(), items: map[string]*list.Element{}}
+}
+
+func (c *lru[V]) get(key string) (v V, ok bool) {
+	e, ok := c.items[key]
+	if !ok {
+		return v, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*lruEntry[V]).val, true
+}
+
+// oldest returns the least recently used key and its value
+func (c *lru[V]) oldest() (key string, v V, ok bool) {
+	e := c.order.Back()
+	if e == nil {
+		return "", v, false
+	}
+	ent := e.Value.(*lruEntry[V])
+	return ent.key, ent.val, true
+}
+
+func (c *lru[V]) remove(key string) {
+	if e, ok := c.items[key]; ok {
+		c.order.Remove(e)
+		delete(c.items, key)
+	}
+}
+
+func (c *lru[V]) put(key string, v V) {
+	if e, ok := c.items[key]; ok {
+		e.Value.(*lruEntry[V]).val = v
+		c.order.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.order.PushFront(&lruEntry[V]{key, v})
+	for max := c.max(); max > 0 && c.order.Len() > max; {
+		e := c.order.Back()
+		c.order.Remove(e)
+		delete(c.items, e.Value.(*lruEntry[V]).key)
+	}
+}
@@ -0,0 +1,182 @@
+package log_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/as/log"
+)
+
+func TestNeverSample(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+	defer func() { log.SampleRate = 0 }()
+	log.SampleRate = 1000
+
+	for i := 0; i < 100; i++ {
+		log.Info.F("noise")
+		log.Error.F("failure")
+	}
+	if n := strings.Count(buf.String(), `"level":"error"`); n != 100 {
+		t.Fatalf("bad error count: have %d, want 100", n)
+	}
+	if n := strings.Count(buf.String(), `"level":"info"`); n > 1 {
+		t.Fatalf("bad info count: have %d, want at most 1", n)
+	}
+}
+
+func TestSampleMeta(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+	defer func() { log.SampleRate, log.SampleMeta = 0, false }()
+	log.SampleRate, log.SampleMeta = 10, true
+
+	for i := 0; i < 30; i++ {
+		log.Info.F("noise")
+	}
+	log.Error.F("failure")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := []string{
+		`{"svc":"test", "ts":12345, "level":"info", "sample_rate":10, "sampled_count":9, "msg":"noise"}`,
+		`{"svc":"test", "ts":12345, "level":"info", "sample_rate":10, "sampled_count":9, "msg":"noise"}`,
+		`{"svc":"test", "ts":12345, "level":"error", "msg":"failure"}`,
+	}
+	if len(lines) != 4 {
+		t.Fatalf("bad line count: have %d, want 4", len(lines))
+	}
+	for i, have := range lines[1:] {
+		if have != want[i] {
+			t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want[i])
+		}
+	}
+}
+
+func TestSetSampling(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+	defer log.SetSampling("info", log.SetSampling("info", 10))
+
+	for i := 0; i < 100; i++ {
+		log.Info.F("flood")
+	}
+	log.Warn.F("unsampled")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 11 {
+		t.Fatalf("bad line count: have %d, want 11", len(lines))
+	}
+	want := []string{
+		`{"svc":"test", "ts":12345, "level":"info", "sample_rate":10, "sampled_count":0, "msg":"flood"}`,
+		`{"svc":"test", "ts":12345, "level":"info", "sample_rate":10, "sampled_count":9, "msg":"flood"}`,
+	}
+	if lines[0] != want[0] || lines[1] != want[1] {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", lines[:2], want)
+	}
+
+	defer log.SetSampling("fatal", log.SetSampling("fatal", 10))
+	defer func() { log.FatalMode = log.FatalPanic }()
+	log.FatalMode = log.FatalLog
+	buf.Reset()
+	for i := 0; i < 3; i++ {
+		log.Fatal.F("never sampled")
+	}
+	if n := strings.Count(buf.String(), "\n"); n != 3 {
+		t.Fatalf("fatal lines sampled: have %d, want 3", n)
+	}
+}
+
+func TestEscalate(t *testing.T) {
+	fake := &log.FakeClock{}
+	defer log.SetClock(log.SetClock(fake))
+	alerts := 0
+	defer func() { log.OnEscalate = nil }()
+	log.OnEscalate = func(l log.Line) log.Line {
+		alerts++
+		return l.Fatal()
+	}
+
+	levels := []string{}
+	for i := 0; i < 4; i++ {
+		levels = append(levels, log.Error.Escalate("db-conn", 3, time.Minute).Level)
+		fake.Advance(time.Second)
+	}
+	fake.Advance(time.Hour)
+	levels = append(levels, log.Error.Escalate("db-conn", 3, time.Minute).Level)
+
+	have := strings.Join(levels, ",")
+	want := "error,error,fatal,fatal,error"
+	if have != want {
+		t.Fatalf("bad levels: have %s, want %s", have, want)
+	}
+	if alerts != 2 {
+		t.Fatalf("bad alert count: have %d, want 2", alerts)
+	}
+}
+
+func TestMaxSampleKeys(t *testing.T) {
+	defer func(n int) { log.MaxSampleKeys = n }(log.MaxSampleKeys)
+	log.MaxSampleKeys = 2
+
+	occurrences := func(key string) string {
+		have := log.Error.Escalate(key, 100, time.Hour).String()
+		_, n, _ := strings.Cut(have, `"occurrences":`)
+		return strings.TrimSuffix(n, "}")
+	}
+	occurrences("evict-1") // forget keys from earlier runs
+	occurrences("evict-2")
+	seen := []string{}
+	for _, key := range strings.Fields("a b a c a b a c") {
+		seen = append(seen, occurrences(key))
+	}
+	have := strings.Join(seen, ",")
+	want := "1,1,2,1,3,1,4,1"
+	if have != want {
+		t.Fatalf("bad occurrences: have %s, want %s", have, want)
+	}
+
+	for i := 0; i < 1000; i++ {
+		occurrences(fmt.Sprint("user", i))
+	}
+	if have := occurrences("a"); have != "1" {
+		t.Fatalf("key not evicted: have %s occurrences, want 1", have)
+	}
+	if have := occurrences("user999"); have != "2" {
+		t.Fatalf("recent key evicted: have %s occurrences, want 2", have)
+	}
+}
+
+func TestReservoir(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+	fake := &log.FakeClock{}
+	defer log.SetClock(log.SetClock(fake))
+
+	stop := log.SetReservoir("info", 10, time.Minute)
+	for i := 0; i < 1000; i++ {
+		log.Info.Add("n", i).F("burst one")
+	}
+	log.Error.F("never held")
+	if n := strings.Count(buf.String(), "\n"); n != 1 {
+		t.Fatalf("lines written before interval end: have %d, want 1", n)
+	}
+	fake.Advance(time.Minute)
+	for i := 0; i < 1000; i++ {
+		log.Info.Add("n", i).F("burst two")
+	}
+	stop()
+	log.Info.F("after stop")
+
+	have := buf.String()
+	if n := strings.Count(have, "burst one"); n != 10 {
+		t.Fatalf("bad sample size for first interval: have %d, want 10", n)
+	}
+	if n := strings.Count(have, "burst two"); n != 10 {
+		t.Fatalf("bad sample size for second interval: have %d, want 10", n)
+	}
+	if !strings.HasSuffix(have, `"msg":"after stop"}`+"\n") {
+		t.Fatalf("line held after stop: %s", have)
+	}
+}
@@ -0,0 +1,91 @@
+package log
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// Scope holds back the lines written through it until its context is
+// done. If any of them was at the error level or above, they are all
+// written in order; otherwise they are discarded. This keeps the
+// details of successful requests out of the log while preserving them
+// for failed ones.
+//
+//	sc := log.NewScope(r.Context())
+//	ln := log.Info.Scope(sc)
+//	ln.F("parsed")            // held
+//	ln.Error().F("db: %v", e) // the request failed: both lines are written
+type Scope struct {
+	mu     sync.Mutex
+	lines  []scoped
+	failed bool
+	closed bool
+	done   chan struct{}
+}
+
+type scoped struct {
+	dst                io.Writer
+	level, s, eol, ref string
+}
+
+// NewScope returns a Scope that is flushed or discarded when ctx is done
+func NewScope(ctx context.Context) *Scope {
+	sc := &Scope{done: make(chan struct{})}
+	go func() {
+		<-ctx.Done()
+		sc.close(false)
+	}()
+	return sc
+}
+
+// Done returns a channel that is closed once the scope's lines have
+// been written or discarded
+func (sc *Scope) Done() <-chan struct{} {
+	return sc.done
+}
+
+// hold buffers the serialized line s bound for dst, with the ref of the
+// stack it carries. It reports false if the scope is closed and the
+// line should be written now.
+func (sc *Scope) hold(dst io.Writer, level, s, eol, ref string) bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.closed {
+		return false
+	}
+	sc.lines = append(sc.lines, scoped{dst, level, s, eol, ref})
+	if weight(level) >= weight(Error.Level) {
+		sc.failed = true
+	}
+	return true
+}
+
+// close writes the held lines if the scope failed or force is set, and
+// closes the scope. Later calls do nothing.
+func (sc *Scope) close(force bool) {
+	sc.mu.Lock()
+	if sc.closed {
+		sc.mu.Unlock()
+		return
+	}
+	lines, failed := sc.lines, sc.failed || force
+	sc.lines, sc.closed = nil, true
+	sc.mu.Unlock()
+	if failed {
+		for _, ln := range lines {
+			if writeTo(ln.dst, ln.level, ln.s, ln.eol) {
+				seeStack(ln.ref)
+			}
+		}
+	}
+	close(sc.done)
+}
+
+// Scope returns a copy of l whose lines are held by sc until its
+// context is done. Fatal lines are never held: they write the held
+// lines and close the scope before being written themselves.
+func (l line) Scope(sc *Scope) line {
+	l.scope = sc
+	return l
+}
@@ -0,0 +1,44 @@
+package log_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/as/log"
+)
+
+func TestScope(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ok := log.NewScope(ctx)
+	log.Info.Scope(ok).F("parsed")
+	log.Warn.Scope(ok).F("slow")
+	cancel()
+	<-ok.Done()
+	if buf.Len() != 0 {
+		t.Fatalf("successful scope written: %s", buf)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	failed := log.NewScope(ctx)
+	log.Info.Scope(failed).F("parsed")
+	log.Error.Scope(failed).F("db down")
+	if buf.Len() != 0 {
+		t.Fatalf("scope written before done: %s", buf)
+	}
+	cancel()
+	<-failed.Done()
+	log.Info.Scope(failed).F("after")
+
+	have := buf.String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "msg":"parsed"}
+{"svc":"test", "ts":12345, "level":"error", "msg":"db down"}
+{"svc":"test", "ts":12345, "level":"info", "msg":"after"}
+`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
@@ -0,0 +1,97 @@
+//go:build go1.21
+
+package log
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Handler is a slog.Handler that emits records as lines of this
+// package, so they share its output, format, and tags. Attributes
+// become fields, and groups prefix the keys of their attributes with
+// the group name and the NamespaceSeparator.
+//
+//	slog.SetDefault(slog.New(log.NewHandler()))
+type Handler struct {
+	ln line
+}
+
+// NewHandler returns a Handler that emits lines derived from Default
+func NewHandler() Handler {
+	return Handler{ln: Default}
+}
+
+// Enabled implements slog.Handler. Debug records are enabled only when
+// DebugOn is set, and all records are subject to the minimum level.
+func (h Handler) Enabled(_ context.Context, level slog.Level) bool {
+	name := slogLevel(level)
+	if name == Debug.Level && !DebugOn {
+		return false
+	}
+	return enabled(name)
+}
+
+// Handle implements slog.Handler. With Caller set, the caller field
+// holds the location recorded by slog rather than a frame inside it.
+func (h Handler) Handle(_ context.Context, r slog.Record) error {
+	ln := h.ln
+	ln.Level = slogLevel(r.Level)
+	ln.pc = r.PC
+	r.Attrs(func(a slog.Attr) bool {
+		ln = ln.addAttr(a)
+		return true
+	})
+	ln.Printf("%s", r.Message)
+	return nil
+}
+
+// WithAttrs implements slog.Handler
+func (h Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	for _, a := range attrs {
+		h.ln = h.ln.addAttr(a)
+	}
+	return h
+}
+
+// WithGroup implements slog.Handler
+func (h Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h.ln = h.ln.Namespace(name)
+	return h
+}
+
+// addAttr adds a to l, flattening groups into namespaced keys
+func (l line) addAttr(a slog.Attr) line {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return l
+	}
+	if a.Value.Kind() != slog.KindGroup {
+		return l.Add(a.Key, a.Value.Any())
+	}
+	ns := l.ns
+	if a.Key != "" {
+		l = l.Namespace(a.Key)
+	}
+	for _, g := range a.Value.Group() {
+		l = l.addAttr(g)
+	}
+	l.ns = ns
+	return l
+}
+
+// slogLevel maps a slog level onto the nearest level at or below it
+func slogLevel(level slog.Level) string {
+	switch {
+	case level < slog.LevelInfo:
+		return Debug.Level
+	case level < slog.LevelWarn:
+		return Info.Level
+	case level < slog.LevelError:
+		return Warn.Level
+	}
+	return Error.Level
+}
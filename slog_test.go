@@ -0,0 +1,52 @@
+//go:build go1.21
+
+package log_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/as/log"
+)
+
+func TestHandler(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+
+	sl := slog.New(log.NewHandler())
+	sl.Info("hello", "user", "joe")
+	sl.Debug("hidden")
+	sl.With("req", 1).WithGroup("db").Warn("slow", "rows", 5, slog.Group("conn", "host", "a"))
+	sl.Log(context.Background(), slog.LevelError+4, "worse", slog.Group("", "inline", true))
+
+	have := buf.String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "user":"joe", "msg":"hello"}
+{"svc":"test", "ts":12345, "level":"warn", "req":1, "db.rows":5, "db.conn.host":"a", "msg":"slow"}
+{"svc":"test", "ts":12345, "level":"error", "inline":true, "msg":"worse"}
+`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestHandlerCaller(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+	defer func() { log.Caller = false }()
+	log.Caller = true
+
+	sl := slog.New(log.NewHandler())
+	_, file, line, _ := runtime.Caller(0)
+	sl.Info("hello")
+
+	have := buf.String()
+	want := fmt.Sprintf(`{"svc":"test", "ts":12345, "level":"info", "caller":"%s:%d", "msg":"hello"}`+"\n", filepath.Base(file), line+1)
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
@@ -0,0 +1,152 @@
+package log
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+var (
+	// Caller adds a caller field before msg with the file and line
+	// that wrote the line
+	Caller = false
+
+	// CallerFullPath reports the caller's full file path instead of
+	// its base name
+	CallerFullPath = false
+
+	// Stack adds the caller's stack in the stack field, as WithStack
+	// does, to lines at the levels listed in StackLevels
+	Stack = false
+
+	// StackLevels lists the levels that get a stack when Stack is set
+	StackLevels = []string{"error", "fatal"}
+
+	// StackDedup writes each distinct stack in full until a line
+	// carrying it is written. Every stack is tagged with a stack_ref
+	// hash, and later lines repeating it carry only the stack_ref.
+	StackDedup = false
+)
+
+// maxFrames limits the number of frames in a stack field
+const maxFrames = 32
+
+// maxStacks limits the number of stacks remembered by StackDedup
+const maxStacks = 1024
+
+var (
+	stacksMu sync.Mutex
+	stacks   = newLRU[struct{}](func() int { return maxStacks })
+)
+
+// stackFields returns the stack fields for a line with stack s. If the
+// full stack is included under StackDedup, its ref is returned too, to
+// be passed to seeStack once the line is written.
+func stackFields(s string) (f fields, ref string) {
+	if !StackDedup {
+		return fields{"stack", s}, ""
+	}
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	ref = fmt.Sprintf("%08x", h.Sum32())
+	stacksMu.Lock()
+	_, seen := stacks.get(ref)
+	stacksMu.Unlock()
+	if seen {
+		return fields{"stack_ref", ref}, ""
+	}
+	return fields{"stack", s, "stack_ref", ref}, ref
+}
+
+// seeStack records that the stack with the given ref was written in
+// full. An empty ref is ignored.
+func seeStack(ref string) {
+	if ref == "" {
+		return
+	}
+	stacksMu.Lock()
+	stacks.put(ref, struct{}{})
+	stacksMu.Unlock()
+}
+
+// ResetStackDedup forgets the stacks written so far, so StackDedup
+// writes each of them in full again, i.e., after switching to a new
+// output that lacks them
+func ResetStackDedup() {
+	stacksMu.Lock()
+	stacks = newLRU[struct{}](func() int { return maxStacks })
+	stacksMu.Unlock()
+}
+
+// pkgPrefix is the qualified name prefix of functions in this package,
+// i.e., "github.com/as/log."
+var pkgPrefix = func() string {
+	pc, _, _, _ := runtime.Caller(0)
+	name := runtime.FuncForPC(pc).Name()
+	dir, base := "", name
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		dir, base = name[:i+1], name[i+1:]
+	}
+	return dir + base[:strings.Index(base, ".")+1]
+}()
+
+// caller returns the file and line of the first frame outside this
+// package. The file is a base name unless CallerFullPath is set.
+func caller() string {
+	pc := make([]uintptr, maxFrames)
+	frames := runtime.CallersFrames(pc[:runtime.Callers(2, pc)])
+	for {
+		fr, more := frames.Next()
+		if !strings.HasPrefix(fr.Function, pkgPrefix) {
+			return location(fr)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// callerAt is like caller, but returns the location of the program
+// counter pc, such as one recorded by log/slog
+func callerAt(pc uintptr) string {
+	fr, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	return location(fr)
+}
+
+func location(fr runtime.Frame) string {
+	file := fr.File
+	if !CallerFullPath {
+		file = filepath.Base(file)
+	}
+	return fmt.Sprintf("%s:%d", file, fr.Line)
+}
+
+// WithStack returns a copy of l that includes the caller's stack in
+// the stack field when written, regardless of the level
+func (l line) WithStack() line {
+	l.stack = true
+	return l
+}
+
+// stack returns the calling goroutine's stack as a single line of
+// space-separated frames, omitting frames inside this package and
+// the runtime
+func stack() string {
+	pc := make([]uintptr, maxFrames+16)
+	frames := runtime.CallersFrames(pc[:runtime.Callers(2, pc)])
+	s := []string{}
+	for len(s) < maxFrames {
+		fr, more := frames.Next()
+		if !strings.HasPrefix(fr.Function, pkgPrefix) && !strings.HasPrefix(fr.Function, "runtime.") {
+			fn := fr.Function[strings.LastIndex(fr.Function, "/")+1:]
+			s = append(s, fmt.Sprintf("%s(%s:%d)", fn, filepath.Base(fr.File), fr.Line))
+		}
+		if !more {
+			break
+		}
+	}
+	return strings.Join(s, " ")
+}
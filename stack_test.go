@@ -0,0 +1,98 @@
+package log_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/as/log"
+)
+
+func TestWithStack(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+
+	log.Info.WithStack().F("traced")
+	log.Info.F("untraced")
+
+	lines := strings.Split(buf.String(), "\n")
+	if !strings.Contains(lines[0], `"stack":"log_test.TestWithStack(stack_test.go:`) {
+		t.Fatalf("missing stack: %s", lines[0])
+	}
+	if strings.Contains(lines[1], `"stack"`) {
+		t.Fatalf("unexpected stack: %s", lines[1])
+	}
+}
+
+func TestStack(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+	defer func() { log.Stack = false }()
+	log.Stack = true
+
+	log.Error.F("traced")
+	log.Info.F("untraced")
+
+	lines := strings.Split(buf.String(), "\n")
+	if !strings.Contains(lines[0], `"stack":"log_test.TestStack(stack_test.go:`) || !strings.HasSuffix(lines[0], `, "msg":"traced"}`) {
+		t.Fatalf("missing stack: %s", lines[0])
+	}
+	if strings.Contains(lines[1], `"stack"`) {
+		t.Fatalf("unexpected stack: %s", lines[1])
+	}
+}
+
+func TestStackDedup(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+	defer func() { log.StackDedup = false }()
+	log.StackDedup = true
+	log.ResetStackDedup()
+
+	// the scoped line is discarded, so its stack is not seen
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sc := log.NewScope(ctx)
+	for i, ln := range []log.Line{log.Info.Scope(sc), log.Error, log.Error} {
+		if i == 1 {
+			cancel()
+			<-sc.Done()
+		}
+		ln.WithStack().F("repeated")
+	}
+
+	lines := strings.Split(buf.String(), "\n")
+	_, ref, _ := strings.Cut(lines[0], `"stack_ref":"`)
+	ref, _, _ = strings.Cut(ref, `"`)
+	if !strings.Contains(lines[0], `"stack":"log_test.TestStackDedup(stack_test.go:`) || len(ref) != 8 {
+		t.Fatalf("missing stack: %s", lines[0])
+	}
+	want := `{"svc":"test", "ts":12345, "level":"error", "stack_ref":"` + ref + `", "msg":"repeated"}`
+	if lines[1] != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", lines[1], want)
+	}
+}
+
+func TestCaller(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+	defer func() { log.Caller, log.CallerFullPath = false, false }()
+	log.Caller = true
+
+	_, file, line, _ := runtime.Caller(0)
+	log.Info.Printf("printf")
+	log.Info.Add("k", "v").F("f")
+	log.CallerFullPath = true
+	log.Error.F("full")
+
+	want := fmt.Sprintf(`{"svc":"test", "ts":12345, "level":"info", "caller":"stack_test.go:%d", "msg":"printf"}
+{"svc":"test", "ts":12345, "level":"info", "k":"v", "caller":"stack_test.go:%d", "msg":"f"}
+{"svc":"test", "ts":12345, "level":"error", "caller":%q, "msg":"full"}
+`, line+1, line+2, fmt.Sprintf("%s:%d", file, line+4))
+	if have := buf.String(); have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
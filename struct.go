@@ -0,0 +1,157 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// ConfigDump logs the exported fields of the struct cfg at info level.
+// Fields tagged with `log:"secret"` have their values redacted, and
+// func and chan fields are skipped.
+//
+//	type Config struct {
+//		Addr     string
+//		Password string `log:"secret"`
+//	}
+func ConfigDump(cfg interface{}) {
+	v := reflect.Indirect(reflect.ValueOf(cfg))
+	if v.Kind() != reflect.Struct {
+		Info.Add("config", cfg).Printf("config")
+		return
+	}
+	Info.Add(structFields(v, visit(cfg))...).Printf("config")
+}
+
+// AddStruct returns a copy of l with the exported fields of the struct
+// v added. The field tag `log:"name"` renames a field, `log:"-"` skips
+// it, and `log:"name,secret"` redacts its value. Func and chan fields
+// are skipped. Nested structs become
+// nested objects, and pointers back to a struct being expanded are
+// omitted. Non-struct values are ignored.
+//
+//	type Request struct {
+//		ID    string `log:"reqid"`
+//		Token string `log:"-"`
+//	}
+func (l line) AddStruct(v interface{}) line {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return l
+	}
+	return l.Add(structFields(rv, visit(v))...)
+}
+
+// visit returns the set of struct pointers being expanded, holding v if
+// it is one
+func visit(v interface{}) map[uintptr]bool {
+	seen := map[uintptr]bool{}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		seen[rv.Pointer()] = true
+	}
+	return seen
+}
+
+// structFields returns the fields of the struct v. Seen holds the
+// pointers to the structs enclosing v, so cycles are cut off.
+func structFields(v reflect.Value, seen map[uintptr]bool) (f fields) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue // unexported
+		}
+		name, secret := parseTag(sf.Tag.Get("log"))
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		if secret {
+			f = append(f, name, redacted)
+			continue
+		}
+		fv := v.Field(i)
+		switch fv.Kind() {
+		case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+			continue // not serializable, i.e., hooks
+		}
+		if nested(fv) {
+			if fv.Kind() != reflect.Ptr {
+				f = append(f, name, object(structFields(fv, seen)))
+				continue
+			}
+			p := fv.Pointer()
+			if seen[p] {
+				continue // cycle
+			}
+			seen[p] = true
+			f = append(f, name, object(structFields(fv.Elem(), seen)))
+			delete(seen, p)
+			continue
+		}
+		f = append(f, name, fv.Interface())
+	}
+	return f
+}
+
+// parseTag splits a log struct tag into its name and secret option.
+// The tag "secret" alone is shorthand for ",secret".
+func parseTag(tag string) (name string, secret bool) {
+	if tag == "secret" {
+		return "", true
+	}
+	opts := strings.Split(tag, ",")
+	for _, o := range opts[1:] {
+		secret = secret || o == "secret"
+	}
+	return opts[0], secret
+}
+
+// nested reports whether v is a struct that should be logged as a
+// nested object rather than serialized on its own
+func nested(v reflect.Value) bool {
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return false
+	}
+	switch v.Interface().(type) {
+	case json.Marshaler, fmt.Stringer, error:
+		return false
+	}
+	if v.CanAddr() {
+		switch v.Addr().Interface().(type) {
+		case json.Marshaler, fmt.Stringer, error:
+			return false
+		}
+	}
+	return true
+}
+
+// object is an ordered list of key-value pairs serialized as a nested
+// JSON object
+type object fields
+
+// Obj returns a value that is written as a nested object holding the
+// key-value pairs kv, in order. Objects without any non-empty values
+// are omitted like other empty values.
+//
+//	log.Info.Add("user", log.Obj("id", 5, "name", "joe")) // "user":{"id":5,"name":"joe"}
+func Obj(kv ...interface{}) object {
+	return object(kv)
+}
+
+// String returns the object as JSON
+func (o object) String() string {
+	return JSON{Sep: ","}.Encode(o)
+}
+
+func (o object) MarshalJSON() ([]byte, error) {
+	return []byte(o.String()), nil
+}
@@ -0,0 +1,106 @@
+package log_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/as/log"
+)
+
+func TestConfigDump(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+
+	type config struct {
+		Addr     string
+		Password string `log:"secret"`
+		Workers  int
+		OnReload func()
+		internal string
+	}
+	log.ConfigDump(&config{Addr: ":80", Password: "hunter2", Workers: 4, OnReload: func() {}, internal: "x"})
+
+	have := strings.TrimSpace(buf.String())
+	want := `{"svc":"test", "ts":12345, "level":"info", "Addr":":80", "Password":"[REDACTED]", "Workers":4, "msg":"config"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestAddStruct(t *testing.T) {
+	type owner struct {
+		Name  string `log:"name"`
+		Email string `log:"email,secret"`
+	}
+	type repo struct {
+		ID       int  `log:"repo_id"`
+		Private  bool `log:"-"`
+		Stars    int
+		Owner    owner `log:"owner"`
+		Created  time.Time
+		Events   chan int
+		Hook     func() error
+		internal string
+	}
+	have := log.Info.AddStruct(repo{
+		ID:       9,
+		Private:  true,
+		Stars:    42,
+		Owner:    owner{"joe", "joe@example.com"},
+		Created:  time.Date(2021, 12, 4, 0, 0, 0, 0, time.UTC),
+		Events:   make(chan int),
+		Hook:     func() error { return nil },
+		internal: "hidden",
+	}).Msg("repo").String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "repo_id":9, "Stars":42, "owner":{"name":"joe","email":"[REDACTED]"}, "Created":"2021-12-04 00:00:00 +0000 UTC", "msg":"repo"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+type node struct {
+	Name string
+	Next *node
+}
+
+func TestAddStructCycle(t *testing.T) {
+	a := &node{Name: "a"}
+	a.Next = &node{Name: "b", Next: a}
+	have := log.Info.AddStruct(a).Msg("ring").String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "Name":"a", "Next":{"Name":"b"}, "msg":"ring"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+	self := &node{Name: "self"}
+	self.Next = self
+	log.ConfigDump(self)
+	have = buf.String()
+	want = `{"svc":"test", "ts":12345, "level":"info", "Name":"self", "msg":"config"}` + "\n"
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestObj(t *testing.T) {
+	ln := log.Info.Add(
+		"user", log.Obj("id", 5, "name", "joe", "org", log.Obj("id", 1)),
+		"empty", log.Obj(),
+		"blank", log.Obj("name", ""),
+	).Msg("nested")
+	have := ln.String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "user":{"id":5,"name":"joe","org":{"id":1}}, "msg":"nested"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+
+	have = ln.Format(log.FormatLogfmt).String()
+	want = `svc=test ts=12345 level=info user="{\"id\":5,\"name\":\"joe\",\"org\":{\"id\":1}}" msg=nested`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
@@ -0,0 +1,42 @@
+package log
+
+import (
+	"io"
+	"strings"
+)
+
+// TB is the subset of testing.TB used by TestLogger
+type TB interface {
+	Helper()
+	Log(args ...interface{})
+	Cleanup(func())
+}
+
+// TestLogger sets the output to t.Log for the remainder of the test,
+// so lines written by the code under test are attributed to the test
+// and only shown when it fails or runs verbosely. The previous output
+// is restored when the test ends. It returns the writer it installed.
+//
+// The output is package-scoped, so tests using TestLogger must not
+// run in parallel.
+//
+//	func TestServer(t *testing.T) {
+//		log.TestLogger(t)
+//		...
+//	}
+func TestLogger(t TB) io.Writer {
+	w := tbWriter{t}
+	old := SetOutput(w)
+	t.Cleanup(func() { SetOutput(old) })
+	return w
+}
+
+type tbWriter struct{ t TB }
+
+func (w tbWriter) Write(p []byte) (int, error) {
+	w.t.Helper()
+	for _, ln := range strings.Split(strings.TrimSuffix(string(p), "\n"), "\n") {
+		w.t.Log(ln)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,33 @@
+package log_test
+
+import (
+	"testing"
+
+	"github.com/as/log"
+)
+
+type fakeTB struct {
+	logs    []string
+	cleanup []func()
+}
+
+func (t *fakeTB) Helper()                 {}
+func (t *fakeTB) Log(args ...interface{}) { t.logs = append(t.logs, args[0].(string)) }
+func (t *fakeTB) Cleanup(fn func())       { t.cleanup = append(t.cleanup, fn) }
+
+func TestTestLogger(t *testing.T) {
+	tb := &fakeTB{}
+	log.TestLogger(tb)
+	log.Error.F("under test")
+	for _, fn := range tb.cleanup {
+		fn()
+	}
+
+	want := `{"svc":"test", "ts":12345, "level":"error", "msg":"under test"}`
+	if len(tb.logs) != 1 || tb.logs[0] != want {
+		t.Fatalf("bad log:\n\t\thave: %q\n\t\twant: %s", tb.logs, want)
+	}
+
+	log.TestLogger(t) // satisfied by *testing.T
+	log.Info.F("visible with -v")
+}
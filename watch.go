@@ -0,0 +1,54 @@
+package log
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// StartHeartbeat emits an info line with event:"heartbeat" and the
+// provided fields once per interval. The returned func stops the
+// heartbeat and waits for the last line to be written.
+func StartHeartbeat(every time.Duration, fields ...interface{}) (stop func()) {
+	ln := Info.Add("event", "heartbeat").Add(fields...)
+	return tick(every, func() { ln.Printf("heartbeat") })
+}
+
+// WatchGoroutines checks the number of goroutines once per interval
+// and emits a warn line with the goroutines and threshold fields when
+// it exceeds threshold, which may indicate a leak. The returned func
+// stops the watch.
+func WatchGoroutines(threshold int, every time.Duration) (stop func()) {
+	ln := Warn.Add("threshold", threshold)
+	return tick(every, func() {
+		if n := runtime.NumGoroutine(); n > threshold {
+			ln.Add("goroutines", n).Printf("possible goroutine leak: %d goroutines", n)
+		}
+	})
+}
+
+// tick runs fn once per interval of the package clock in its own
+// goroutine until the returned func is called
+func tick(every time.Duration, fn func()) (stop func()) {
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	c, stopTicker := newTicker(every)
+	go func() {
+		defer wg.Done()
+		defer stopTicker()
+		for {
+			select {
+			case <-done:
+				return
+			case <-c:
+				fn()
+			}
+		}
+	}()
+	once := sync.Once{}
+	return func() {
+		once.Do(func() { close(done) })
+		wg.Wait()
+	}
+}
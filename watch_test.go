@@ -0,0 +1,68 @@
+package log_test
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/as/log"
+)
+
+func TestHeartbeat(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+
+	stop := log.StartHeartbeat(time.Millisecond, "pid", 1)
+	time.Sleep(20 * time.Millisecond)
+	stop()
+	stop()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) < 2 {
+		t.Fatalf("want at least two heartbeats, have %d", len(lines))
+	}
+	want := `{"svc":"test", "ts":12345, "level":"info", "event":"heartbeat", "pid":1, "msg":"heartbeat"}`
+	for _, have := range lines {
+		if have != want {
+			t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+		}
+	}
+}
+
+func TestWatchGoroutines(t *testing.T) {
+	buf := &gateWriter{open: make(chan struct{})}
+	close(buf.open)
+	defer log.SetOutput(log.SetOutput(buf))
+
+	base := runtime.NumGoroutine()
+	stop := log.WatchGoroutines(base+5, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if buf.String() != "" {
+		stop()
+		t.Fatalf("warned below threshold: %s", buf)
+	}
+
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() { defer wg.Done(); <-done }()
+	}
+	time.Sleep(20 * time.Millisecond)
+	stop()
+	close(done)
+	wg.Wait()
+
+	have := strings.SplitN(buf.String(), "\n", 2)[0]
+	var n int
+	_, count, _ := strings.Cut(have, `"goroutines":`)
+	fmt.Sscanf(count, "%d", &n)
+	want := fmt.Sprintf(`{"svc":"test", "ts":12345, "level":"warn", "threshold":%d, "goroutines":%d, "msg":"possible goroutine leak: %d goroutines"}`, base+5, n, n)
+	if have != want || n <= base+5 {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
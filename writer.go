@@ -0,0 +1,434 @@
+package log
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ChecksumWriter returns a writer that appends a tab and the hex
+// CRC-32 (IEEE) of every line written to it before passing the line
+// to w. Receivers can split on the last tab to verify the line.
+//
+// Lines are split on newlines, so it only works with newline-delimited
+// encoders. Output from a framed encoder such as MsgPack, which has no
+// newlines and whose length prefix a checksum would invalidate, is
+// buffered and never passed to w.
+func ChecksumWriter(w io.Writer) io.Writer {
+	return &checksumWriter{w: w}
+}
+
+type checksumWriter struct {
+	mu  sync.Mutex
+	w   io.Writer
+	buf []byte
+}
+
+func (c *checksumWriter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.buf = append(c.buf, p...)
+	out := []byte{}
+	for {
+		i := bytes.IndexByte(c.buf, '\n')
+		if i < 0 {
+			break
+		}
+		ln := c.buf[:i]
+		out = append(out, ln...)
+		out = append(out, fmt.Sprintf("\t%08x\n", crc32.ChecksumIEEE(ln))...)
+		c.buf = c.buf[i+1:]
+	}
+	c.buf = append([]byte{}, c.buf...)
+	if len(out) == 0 {
+		return len(p), nil
+	}
+	if _, err := c.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Writer returns a writer that emits every line written to it as the
+// msg of a copy of l, for libraries that only log to an io.Writer.
+// Partial lines are held until their newline arrives, and empty lines
+// are dropped.
+//
+//	srv.ErrorLog = stdlog.New(log.Error.Add("src", "http").Writer(), "", 0)
+func (l line) Writer() io.Writer {
+	return &lineWriter{ln: l}
+}
+
+type lineWriter struct {
+	mu  sync.Mutex
+	ln  line
+	buf []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if msg := bytes.TrimSuffix(w.buf[:i], []byte("\r")); len(msg) > 0 {
+			w.ln.F("%s", msg)
+		}
+		w.buf = w.buf[i+1:]
+	}
+	w.buf = append([]byte{}, w.buf...)
+	return len(p), nil
+}
+
+// RotatingFile is an io.WriteCloser that appends to the file at Path
+// and rotates it once it would grow past MaxBytes. On rotation the
+// file is renamed with a timestamp suffix, and the new file begins
+// with an info line marked event:"rotated".
+type RotatingFile struct {
+	Path     string
+	MaxBytes int64
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// OpenRotating opens or creates the file at path for appending and
+// returns a RotatingFile for it
+func OpenRotating(path string, maxBytes int64) (*RotatingFile, error) {
+	r := &RotatingFile{Path: path, MaxBytes: maxBytes}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, fi.Size()
+	return nil
+}
+
+// Write implements io.Writer
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.MaxBytes > 0 && r.size > 0 && r.size+int64(len(p)) > r.MaxBytes {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Rotate rotates the file immediately
+func (r *RotatingFile) Rotate() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rotate()
+}
+
+func (r *RotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	old := r.Path + "." + Now().Format("20060102T150405.000000000")
+	if err := os.Rename(r.Path, old); err != nil {
+		return err
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	ln := Info.Add("event", "rotated", "path", r.Path, "previous", old).Msg("rotated")
+	n, err := io.WriteString(r.f, ln.String()+ln.eol())
+	r.size += int64(n)
+	return err
+}
+
+// Close closes the file
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
+
+// ChannelWriter returns a writer that parses every JSON line written
+// to it and sends the result to ch. The svc, ts, level, and msg fields
+// populate the line's header; the rest become its fields. If ch is
+// full, the line is dropped rather than blocking the logger.
+//
+//	ch := make(chan log.Line, 64)
+//	log.SetOutput(log.ChannelWriter(ch))
+func ChannelWriter(ch chan<- Line) io.Writer {
+	return channelWriter(ch)
+}
+
+type channelWriter chan<- Line
+
+func (c channelWriter) Write(p []byte) (int, error) {
+	for _, data := range bytes.Split(p, []byte("\n")) {
+		if len(bytes.TrimSpace(data)) == 0 {
+			continue
+		}
+		ln, err := parse(data)
+		if err != nil {
+			return 0, err
+		}
+		select {
+		case c <- ln:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+// parse decodes a JSON line into a Line, preserving the field order
+func parse(data []byte) (ln line, err error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return ln, fmt.Errorf("log: parse: not a json object: %q", data)
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return ln, err
+		}
+		key, _ := t.(string)
+		var val interface{}
+		if err := dec.Decode(&val); err != nil {
+			return ln, err
+		}
+		if n, ok := val.(json.Number); ok {
+			if val, err = n.Int64(); err != nil {
+				val, _ = n.Float64()
+			}
+		}
+		switch key {
+		case "svc", "ts":
+		case "level":
+			ln.Level = fmt.Sprint(val)
+		case "msg":
+			ln.msg = fmt.Sprint(val)
+		default:
+			ln.fields = append(ln.fields, key, val)
+		}
+	}
+	return ln, nil
+}
+
+// Async is a writer that queues lines and writes them to the
+// underlying writer from a background goroutine, preserving their
+// order. Lines at the levels listed in SyncLevels are written
+// synchronously: the write returns once the line and every line queued
+// before it reach the underlying writer.
+type Async struct {
+	// SyncLevels lists the levels written synchronously. It must be set
+	// before the first write.
+	SyncLevels []string
+
+	// Drop discards lines written while the queue is full instead of
+	// blocking until there is room. Lines at SyncLevels are never
+	// dropped. It must be set before the first write.
+	Drop bool
+
+	w       io.Writer
+	q       chan asyncLine
+	done    chan struct{}
+	mu      sync.RWMutex
+	shut    bool
+	dropped int64
+}
+
+type asyncLine struct {
+	p    []byte
+	sent chan struct{}
+}
+
+// AsyncWriter returns an Async writer for w that queues up to bufSize
+// lines. Errors and fatals are written synchronously by default.
+//
+//	a := log.AsyncWriter(os.Stderr, 1024)
+//	defer a.Close()
+//	log.SetOutput(a)
+func AsyncWriter(w io.Writer, bufSize int) *Async {
+	a := &Async{
+		SyncLevels: []string{"error", "fatal"},
+		w:          w,
+		q:          make(chan asyncLine, bufSize),
+		done:       make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+func (a *Async) run() {
+	defer close(a.done)
+	for ln := range a.q {
+		if ln.p != nil {
+			a.w.Write(ln.p)
+		}
+		if ln.sent != nil {
+			close(ln.sent)
+		}
+	}
+}
+
+// Write queues p
+func (a *Async) Write(p []byte) (int, error) {
+	return a.WriteLevel("", p)
+}
+
+// WriteLevel implements LevelWriter. It queues p, and waits for it to
+// be written if level is in SyncLevels.
+func (a *Async) WriteLevel(level string, p []byte) (int, error) {
+	ln := asyncLine{p: append([]byte{}, p...)}
+	if contains(a.SyncLevels, level) {
+		ln.sent = make(chan struct{})
+	}
+	a.mu.RLock()
+	if a.shut {
+		a.mu.RUnlock()
+		return 0, io.ErrClosedPipe
+	}
+	if a.Drop && ln.sent == nil {
+		select {
+		case a.q <- ln:
+		default:
+			atomic.AddInt64(&a.dropped, 1)
+		}
+	} else {
+		a.q <- ln
+	}
+	a.mu.RUnlock()
+	if ln.sent != nil {
+		<-ln.sent
+	}
+	return len(p), nil
+}
+
+// Flush waits for the queued lines to be written and then flushes the
+// underlying writer, if it has a Flush or Sync method
+func (a *Async) Flush() error {
+	ln := asyncLine{sent: make(chan struct{})}
+	a.mu.RLock()
+	if a.shut {
+		a.mu.RUnlock()
+		return flush(a.w)
+	}
+	a.q <- ln
+	a.mu.RUnlock()
+	<-ln.sent
+	return flush(a.w)
+}
+
+// Dropped returns the number of lines discarded because the queue was
+// full and Drop was set
+func (a *Async) Dropped() int64 {
+	return atomic.LoadInt64(&a.dropped)
+}
+
+// Close writes the queued lines and stops the background goroutine.
+// Writes after Close fail with io.ErrClosedPipe.
+func (a *Async) Close() error {
+	a.mu.Lock()
+	if !a.shut {
+		a.shut = true
+		close(a.q)
+	}
+	a.mu.Unlock()
+	<-a.done
+	return nil
+}
+
+// Batch is a writer that collects lines into gzip-compressed batches
+// and hands each batch to an upload func, e.g., to ship logs to object
+// storage. A batch is uploaded once it holds the configured number of
+// lines or when the interval elapses, whichever happens first.
+type Batch struct {
+	mu     sync.Mutex
+	upload func(gz []byte) error
+	max    int
+	n      int
+	buf    bytes.Buffer
+	stop   func()
+}
+
+// GzipBatchWriter returns a Batch that uploads every lines lines and,
+// if every is positive, at least once per interval. Close uploads the
+// final batch. A batch whose upload fails is kept and retried with the
+// lines that follow it.
+func GzipBatchWriter(lines int, every time.Duration, upload func(gz []byte) error) *Batch {
+	b := &Batch{upload: upload, max: lines}
+	if every > 0 {
+		b.stop = tick(every, func() { b.Flush() })
+	}
+	return b
+}
+
+// Write adds p to the current batch, uploading it if full
+func (b *Batch) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Write(p)
+	b.n += bytes.Count(p, []byte("\n"))
+	if b.max > 0 && b.n >= b.max {
+		if err := b.flush(); err != nil {
+			return len(p), err // p is kept for the retry
+		}
+	}
+	return len(p), nil
+}
+
+// Flush uploads the current batch, if any
+func (b *Batch) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.flush()
+}
+
+func (b *Batch) flush() error {
+	if b.buf.Len() == 0 {
+		return nil
+	}
+	gz := &bytes.Buffer{}
+	zw := gzip.NewWriter(gz)
+	zw.Write(b.buf.Bytes())
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := b.upload(gz.Bytes()); err != nil {
+		return err
+	}
+	b.buf.Reset()
+	b.n = 0
+	return nil
+}
+
+// Close stops the interval timer and uploads the final batch
+func (b *Batch) Close() error {
+	if b.stop != nil {
+		b.stop()
+	}
+	return b.Flush()
+}
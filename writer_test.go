@@ -0,0 +1,357 @@
+package log_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/as/log"
+)
+
+func TestChecksumWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(log.ChecksumWriter(buf)))
+
+	log.Info.F("first")
+	log.Error.Add("err", "EOF").F("second")
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("bad line count: have %d, want 2: %q", len(lines), buf)
+	}
+	for _, ln := range lines {
+		i := strings.LastIndex(ln, "\t")
+		if i < 0 {
+			t.Fatalf("no checksum: %q", ln)
+		}
+		have, want := ln[i+1:], fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(ln[:i])))
+		if have != want {
+			t.Fatalf("bad checksum for %q: have %s, want %s", ln[:i], have, want)
+		}
+	}
+}
+
+func TestChecksumWriterFramed(t *testing.T) {
+	defer func(f log.Encoder) { log.Format = f }(log.Format)
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(log.ChecksumWriter(buf)))
+
+	log.Format = log.MsgPack
+	log.Info.F("framed")
+	if buf.Len() != 0 {
+		t.Fatalf("framed line passed through: %q", buf)
+	}
+}
+
+func TestLineWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	defer log.SetOutput(log.SetOutput(buf))
+
+	w := log.Error.Add("src", "lib").Writer()
+	io.WriteString(w, "first\nsec")
+	io.WriteString(w, "ond\n\n")
+
+	have := buf.String()
+	want := `{"svc":"test", "ts":12345, "level":"error", "src":"lib", "msg":"first"}
+{"svc":"test", "ts":12345, "level":"error", "src":"lib", "msg":"second"}
+`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "svc.log")
+	f, err := log.OpenRotating(path, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	defer log.SetOutput(log.SetOutput(f))
+
+	for i := 0; i < 4; i++ {
+		log.Info.Add("n", i).F("some line that takes up space")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := strings.SplitN(string(data), "\n", 2)[0]
+	want := fmt.Sprintf(`{"svc":"test", "ts":12345, "level":"info", "event":"rotated", "path":%q, "previous":%q`, path, path+".")
+	want = strings.TrimSuffix(want, `"`)
+	if !strings.HasPrefix(first, want) {
+		t.Fatalf("bad marker:\n\t\thave: %s\n\t\twant: %s...", first, want)
+	}
+	old, _ := filepath.Glob(path + ".*")
+	if len(old) == 0 {
+		t.Fatal("no rotated files")
+	}
+}
+
+func TestRotatingFileMsgPack(t *testing.T) {
+	defer func(f log.Encoder) { log.Format = f }(log.Format)
+	path := filepath.Join(t.TempDir(), "svc.log")
+	f, err := log.OpenRotating(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	defer log.SetOutput(log.SetOutput(f))
+
+	log.Format = log.MsgPack
+	if err := f.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	log.Info.F("after")
+	p, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if len(p) < 4 {
+			t.Fatalf("frame %d: missing length prefix", i)
+		}
+		n := int(binary.BigEndian.Uint32(p))
+		if len(p) < 4+n {
+			t.Fatalf("frame %d: have %d bytes, want %d", i, len(p)-4, n)
+		}
+		if _, rest := decodeMsgPack(t, p[4:4+n]); len(rest) != 0 {
+			t.Fatalf("frame %d: %d trailing bytes", i, len(rest))
+		}
+		p = p[4+n:]
+	}
+	if len(p) != 0 {
+		t.Fatalf("%d stray bytes after the last frame", len(p))
+	}
+}
+
+func TestChannelWriter(t *testing.T) {
+	ch := make(chan log.Line, 1)
+	defer log.SetOutput(log.SetOutput(log.ChannelWriter(ch)))
+
+	log.Error.Add("user", "joe", "attempts", 3).F("login failed")
+	log.Info.F("dropped, channel is full")
+
+	ln := <-ch
+	if ln.Level != "error" {
+		t.Fatalf("bad level: have %q, want error", ln.Level)
+	}
+	have := ln.String()
+	want := `{"svc":"test", "ts":12345, "level":"error", "user":"joe", "attempts":3, "msg":"login failed"}`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+	select {
+	case ln := <-ch:
+		t.Fatalf("line not dropped: %s", ln)
+	default:
+	}
+}
+
+// gateWriter blocks writes until open is closed
+type gateWriter struct {
+	open chan struct{}
+	mu   sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (w *gateWriter) Write(p []byte) (int, error) {
+	<-w.open
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *gateWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+func TestAsyncSyncLevels(t *testing.T) {
+	w := &gateWriter{open: make(chan struct{})}
+	a := log.AsyncWriter(w, 16)
+	defer a.Close()
+	defer log.SetOutput(log.SetOutput(a))
+
+	log.Info.F("buffered") // returns while the writer is blocked
+	if have := w.String(); have != "" {
+		t.Fatalf("info line not buffered: %s", have)
+	}
+	close(w.open)
+	log.Error.F("flushed")
+
+	have := w.String()
+	want := `{"svc":"test", "ts":12345, "level":"info", "msg":"buffered"}
+{"svc":"test", "ts":12345, "level":"error", "msg":"flushed"}
+`
+	if have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestAsyncClose(t *testing.T) {
+	w := &gateWriter{open: make(chan struct{})}
+	a := log.AsyncWriter(w, 100)
+	defer log.SetOutput(log.SetOutput(a))
+
+	want := ""
+	for i := 0; i < 100; i++ {
+		log.Info.F("line %d", i)
+		want += fmt.Sprintf(`{"svc":"test", "ts":12345, "level":"info", "msg":"line %d"}`+"\n", i)
+	}
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		close(w.open)
+	}()
+	a.Close()
+	if have := w.String(); have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+	if _, err := a.Write([]byte("late\n")); err != io.ErrClosedPipe {
+		t.Fatalf("write after close: have %v, want %v", err, io.ErrClosedPipe)
+	}
+}
+
+func TestAsyncFlush(t *testing.T) {
+	w := &gateWriter{open: make(chan struct{})}
+	a := log.AsyncWriter(w, 16)
+	defer a.Close()
+	defer log.SetOutput(log.SetOutput(a))
+
+	log.Info.F("queued")
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		close(w.open)
+	}()
+	log.Flush()
+	want := `{"svc":"test", "ts":12345, "level":"info", "msg":"queued"}` + "\n"
+	if have := w.String(); have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+func TestAsyncDrop(t *testing.T) {
+	w := &gateWriter{open: make(chan struct{})}
+	a := log.AsyncWriter(w, 1)
+	a.Drop = true
+	defer log.SetOutput(log.SetOutput(a))
+
+	for i := 0; i < 10; i++ {
+		log.Info.F("line %d", i) // never blocks
+	}
+	close(w.open)
+	log.Error.F("kept")
+	a.Close()
+
+	lines := strings.Split(strings.TrimSpace(w.String()), "\n")
+	if n := a.Dropped(); n < 8 || int(n)+len(lines) != 11 {
+		t.Fatalf("bad drop count: %d dropped, %d written", n, len(lines))
+	}
+	if have, want := lines[len(lines)-1], `{"svc":"test", "ts":12345, "level":"error", "msg":"kept"}`; have != want {
+		t.Fatalf("bad log:\n\t\thave: %s\n\t\twant: %s", have, want)
+	}
+}
+
+// slowWriter discards lines after a delay, like a writer to a remote sink
+type slowWriter struct{}
+
+func (slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(10 * time.Microsecond)
+	return len(p), nil
+}
+
+func BenchmarkAsyncWriter(b *testing.B) {
+	b.Run("Sync", func(b *testing.B) {
+		defer log.SetOutput(log.SetOutput(slowWriter{}))
+		for n := 0; n < b.N; n++ {
+			log.Info.F("count: %d", n)
+		}
+	})
+	b.Run("Async", func(b *testing.B) {
+		a := log.AsyncWriter(slowWriter{}, b.N)
+		defer log.SetOutput(log.SetOutput(a))
+		for n := 0; n < b.N; n++ {
+			log.Info.F("count: %d", n)
+		}
+		b.StopTimer()
+		a.Close()
+	})
+}
+
+func TestGzipBatchWriterRetry(t *testing.T) {
+	fail := true
+	batches := []string{}
+	b := log.GzipBatchWriter(2, 0, func(gz []byte) error {
+		if fail {
+			fail = false
+			return io.ErrShortWrite
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(gz))
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadAll(zr)
+		batches = append(batches, string(data))
+		return err
+	})
+	io.WriteString(b, "one\n")
+	if _, err := io.WriteString(b, "two\n"); err != io.ErrShortWrite {
+		t.Fatalf("bad upload error: have %v, want %v", err, io.ErrShortWrite)
+	}
+	if _, err := io.WriteString(b, "three\n"); err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+	if have, want := strings.Join(batches, "|"), "one\ntwo\nthree\n"; have != want {
+		t.Fatalf("failed batch not retried:\n\t\thave: %q\n\t\twant: %q", have, want)
+	}
+}
+
+func TestGzipBatchWriter(t *testing.T) {
+	batches := []string{}
+	b := log.GzipBatchWriter(2, time.Hour, func(gz []byte) error {
+		zr, err := gzip.NewReader(bytes.NewReader(gz))
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadAll(zr)
+		batches = append(batches, string(data))
+		return err
+	})
+	defer log.SetOutput(log.SetOutput(b))
+
+	log.Info.F("one")
+	log.Info.F("two")
+	log.Info.F("three")
+	if len(batches) != 1 {
+		t.Fatalf("bad batch count before close: have %d, want 1", len(batches))
+	}
+	b.Close()
+
+	want := []string{
+		`{"svc":"test", "ts":12345, "level":"info", "msg":"one"}
+{"svc":"test", "ts":12345, "level":"info", "msg":"two"}
+`,
+		`{"svc":"test", "ts":12345, "level":"info", "msg":"three"}
+`,
+	}
+	if len(batches) != len(want) {
+		t.Fatalf("bad batch count: have %d, want %d", len(batches), len(want))
+	}
+	for i := range want {
+		if batches[i] != want[i] {
+			t.Fatalf("bad batch %d:\n\t\thave: %s\n\t\twant: %s", i, batches[i], want[i])
+		}
+	}
+}